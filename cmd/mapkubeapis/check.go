@@ -0,0 +1,169 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// Exit codes of the check command, which exits with 0 if all releases are clean
+const (
+	checkExitDeprecated = 1
+	checkExitRemoved    = 2
+	checkExitError      = 3
+)
+
+// exitError is an error which sets the exit code of the plugin
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func newCheckCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [flags] [RELEASE...]",
+		Short: "Check releases for deprecated or removed Kubernetes APIs without updating them",
+		Long: `Check releases for deprecated or removed Kubernetes APIs without updating them.
+
+The releases are only read, they are never updated and no backup is taken.
+The exit code is the worst result of the checked releases:
+  0  no deprecated or removed APIs
+  1  deprecated APIs only
+  2  removed APIs
+  3  a release could not be checked`,
+		SilenceUsage: true,
+		Args: func(_ *cobra.Command, args []string) error {
+			if err := validateOutput(settings.Output); err != nil {
+				return &exitError{checkExitError, err}
+			}
+			if err := validateRevision(settings.Revision, len(args), settings.All || settings.AllNamespaces); err != nil {
+				return &exitError{checkExitError, err}
+			}
+			if settings.All || settings.AllNamespaces {
+				if len(args) > 0 {
+					return &exitError{checkExitError, errors.New("a release name may not be passed with --all or --all-namespaces")}
+				}
+				return nil
+			}
+			if len(args) == 0 {
+				return &exitError{checkExitError, errors.New("at least one release name, or --all or --all-namespaces, must be passed")}
+			}
+			return nil
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			return runCheck(out, args)
+		},
+	}
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &exitError{checkExitError, err}
+	})
+
+	settings.AddCheckFlags(cmd.Flags())
+
+	return cmd
+}
+
+func runCheck(out io.Writer, args []string) error {
+	mapFiles, noDefaultMapFile := settings.mapFiles()
+	options := common.MapOptions{
+		Discovery:        settings.Discovery,
+		KubeConfig:       settings.KubeConfig(),
+		KubeVersion:      settings.KubeVersion,
+		MapFiles:         mapFiles,
+		NoDefaultMapFile: noDefaultMapFile,
+		ReleaseNamespace: settings.Namespace,
+		Revision:         settings.Revision,
+		Strict:           settings.Strict,
+		Structured:       settings.Structured,
+		TargetVersion:    settings.TargetVersion,
+	}
+
+	var reports []*common.ReleaseReport
+	if settings.All || settings.AllNamespaces {
+		var err error
+		if reports, err = v3.ForEachRelease(options, settings.AllNamespaces, check); err != nil {
+			return &exitError{checkExitError, err}
+		}
+	} else {
+		for _, name := range args {
+			releaseOptions := options
+			releaseOptions.ReleaseName = name
+			report, err := check(releaseOptions)
+			if err != nil {
+				report.Message = err.Error()
+			}
+			reports = append(reports, report)
+		}
+	}
+
+	var err error
+	switch {
+	case settings.Output != "" && len(args) == 1:
+		err = printReport(out, settings.Output, reports[0])
+	case settings.Output != "":
+		err = printReport(out, settings.Output, reports)
+	default:
+		err = printSummary(out, reports, false)
+	}
+	if err != nil {
+		return &exitError{checkExitError, err}
+	}
+	return checkResult(reports)
+}
+
+// check checks a release and logs the error if it fails
+func check(options common.MapOptions) (*common.ReleaseReport, error) {
+	report, err := v3.CheckRelease(options)
+	if err != nil {
+		log.Printf("Failed to check release '%s': %s\n", options.ReleaseName, err)
+	}
+	return report, err
+}
+
+// checkResult returns an error with the exit code of the worst release status, or nil if all releases are clean
+func checkResult(reports []*common.ReleaseReport) error {
+	counts := make(map[common.ReleaseStatus]int)
+	for _, report := range reports {
+		counts[report.Status]++
+	}
+
+	switch {
+	case counts[common.StatusFailed] > 0:
+		return &exitError{checkExitError, fmt.Errorf("failed to check %d of %d releases", counts[common.StatusFailed], len(reports))}
+	case counts[common.StatusRemoved] > 0:
+		return &exitError{checkExitRemoved, fmt.Errorf("removed APIs found in %d of %d releases", counts[common.StatusRemoved], len(reports))}
+	case counts[common.StatusDeprecated] > 0:
+		return &exitError{checkExitDeprecated, fmt.Errorf("deprecated APIs found in %d of %d releases", counts[common.StatusDeprecated], len(reports))}
+	}
+	return nil
+}
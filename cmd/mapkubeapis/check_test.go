@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// exitCode returns the exit code of the result of the check command, 0 if it is not an error
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	return 0
+}
+
+var _ = ginkgo.Describe("the exit code of the check command", func() {
+	ginkgo.DescribeTable("is the worst status of the releases",
+		func(code int, statuses ...common.ReleaseStatus) {
+			reports := []*common.ReleaseReport{}
+			for _, status := range statuses {
+				reports = append(reports, &common.ReleaseReport{Status: status})
+			}
+			gomega.Expect(exitCode(checkResult(reports))).To(gomega.Equal(code))
+		},
+		ginkgo.Entry("clean", 0, common.StatusClean, common.StatusClean),
+		ginkgo.Entry("no releases", 0),
+		ginkgo.Entry("skipped", 0, common.StatusClean, common.StatusSkipped),
+		ginkgo.Entry("deprecated", 1, common.StatusClean, common.StatusDeprecated),
+		ginkgo.Entry("removed", 2, common.StatusDeprecated, common.StatusRemoved),
+		ginkgo.Entry("failed over removed", 3, common.StatusRemoved, common.StatusFailed, common.StatusDeprecated),
+	)
+
+	ginkgo.It("is 2 for resources which the cluster does not serve with discovery", func() {
+		rel := &release.Release{
+			Name:      "test",
+			Namespace: "test-ns",
+			Version:   1,
+			Manifest:  "---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: test\n",
+			Info:      &release.Info{Status: release.StatusDeployed},
+		}
+		releases := storage.Init(driver.NewMemory())
+		gomega.Expect(releases.Create(rel)).To(gomega.Succeed())
+		served := common.NewServedAPIs([]*metav1.APIResourceList{{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{{Kind: "Deployment"}},
+		}})
+
+		mapper := v3.NewMapper(releases, common.StaticVersion("v1.29.0"), common.MapFiles{}, v3.MapperOptions{ServedAPIs: served, ReportUnserved: true})
+		report, err := mapper.Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Mappings).To(gomega.BeEmpty())
+		gomega.Expect(report.Unsupported).To(gomega.HaveLen(1))
+
+		gomega.Expect(exitCode(checkResult([]*common.ReleaseReport{report}))).To(gomega.Equal(checkExitRemoved))
+	})
+})
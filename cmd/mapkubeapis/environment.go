@@ -17,16 +17,42 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+
 	"github.com/spf13/pflag"
+
+	"github.com/helm/helm-mapkubeapis/config"
+	"github.com/helm/helm-mapkubeapis/pkg/common"
 )
 
 // EnvSettings defined settings
 type EnvSettings struct {
-	DryRun         bool
-	KubeConfigFile string
-	KubeContext    string
-	MapFile        string
-	Namespace      string
+	All               bool
+	AllNamespaces     bool
+	BackupConfigMap   bool
+	BackupDir         string
+	Diff              bool
+	Discovery         bool
+	DryRun            bool
+	KubeConfigFile    string
+	KubeContext       string
+	KubeVersion       string
+	MapFiles          []string
+	MapOn             string
+	Namespace         string
+	NoDefaultMapFile  bool
+	Output            string
+	Revision          int
+	SchemaDir         string
+	StorageConnection string
+	StorageDriver     string
+	Strict            bool
+	Structured        bool
+	TargetVersion     string
+	Validate          bool
 }
 
 // New returns default env settings
@@ -43,8 +69,70 @@ func (s *EnvSettings) AddBaseFlags(fs *pflag.FlagSet) {
 // AddFlags binds flags to the given flagset.
 func (s *EnvSettings) AddFlags(fs *pflag.FlagSet) {
 	s.AddBaseFlags(fs)
+	fs.BoolVar(&s.BackupConfigMap, "backup-configmap", false, "store release backups in ConfigMaps in the release namespace instead of the backup directory")
+	fs.StringVar(&s.BackupDir, "backup-dir", s.BackupDir, "directory to store release backups in")
 	fs.StringVar(&s.KubeConfigFile, "kubeconfig", "", "path to the kubeconfig file")
 	fs.StringVar(&s.KubeContext, "kube-context", s.KubeContext, "name of the kubeconfig context to use")
-	fs.StringVar(&s.MapFile, "mapfile", s.MapFile, "path to the API mapping file")
 	fs.StringVar(&s.Namespace, "namespace", s.Namespace, "namespace scope of the release")
+	fs.StringVar(&s.StorageDriver, "storage-driver", s.StorageDriver, "Helm storage driver of the releases: secret, configmap, sql or memory (default $HELM_DRIVER or secret)")
+	fs.StringVar(&s.StorageConnection, "storage-connection", s.StorageConnection, "connection string of the sql storage driver, or release files to load into the memory storage driver (default $HELM_DRIVER_SQL_CONNECTION_STRING or $HELM_MEMORY_DRIVER_DATA)")
+}
+
+// KubeConfig returns the Kubernetes configuration of the settings
+func (s *EnvSettings) KubeConfig() common.KubeConfig {
+	return common.KubeConfig{
+		Context:           s.KubeContext,
+		File:              s.KubeConfigFile,
+		StorageDriver:     s.StorageDriver,
+		StorageConnection: s.StorageConnection,
+	}
+}
+
+// AddMapfileFlags binds the flags which select the API mapping files to the given flagset.
+func (s *EnvSettings) AddMapfileFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&s.MapFiles, "mapfile", s.MapFiles, "path to an API mapping file layered over the default mappings, can be repeated with later files taking precedence")
+	fs.BoolVar(&s.NoDefaultMapFile, "no-default-mapfile", false, "do not use the default mappings compiled into the plugin, only the --mapfile files")
+}
+
+// mapFiles returns the mapping files of the settings and whether the default mappings are not used.
+// The mapping file installed with the plugin, $HELM_PLUGIN_DIR/config/Map.yaml, used to be read as the
+// default mappings. If it was edited so that it differs from the default mappings compiled into the
+// plugin, it is still used instead of them, with a warning that this is deprecated.
+func (s *EnvSettings) mapFiles() ([]string, bool) {
+	pluginDir := os.Getenv("HELM_PLUGIN_DIR")
+	if s.NoDefaultMapFile || pluginDir == "" {
+		return s.MapFiles, s.NoDefaultMapFile
+	}
+
+	installed := filepath.Join(pluginDir, "config", "Map.yaml")
+	b, err := os.ReadFile(installed)
+	if err != nil || bytes.Equal(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")), config.DefaultMapfile) {
+		return s.MapFiles, false
+	}
+	log.Printf("WARNING: %s was edited and is used instead of the default mappings. This is deprecated and "+
+		"the file is replaced when the plugin is updated, pass the edited file with --mapfile instead.\n", installed)
+	return append([]string{installed}, s.MapFiles...), true
+}
+
+// AddCheckFlags binds the flags shared by the map and check commands to the given flagset.
+func (s *EnvSettings) AddCheckFlags(fs *pflag.FlagSet) {
+	s.AddMapfileFlags(fs)
+	fs.BoolVar(&s.All, "all", false, "include all releases in the namespace")
+	fs.BoolVar(&s.AllNamespaces, "all-namespaces", false, "include all releases in all namespaces")
+	fs.BoolVar(&s.Discovery, "discovery", false, "report resources whose API is not served by the cluster, even if the API is not in the mapping file")
+	fs.StringVar(&s.KubeVersion, "kube-version", s.KubeVersion, "Kubernetes version to map against instead of the version of the cluster, e.g. v1.29.0")
+	fs.StringVarP(&s.Output, "output", "o", s.Output, "print a report of the deprecated or removed APIs found in the given format: json or yaml")
+	fs.IntVar(&s.Revision, "revision", 0, "release version to map or check instead of the latest deployed version, for a single release only")
+	fs.BoolVar(&s.Strict, "strict", false, "fail instead of warning when the mapping files do not cover the Kubernetes version")
+	fs.BoolVar(&s.Structured, "structured", false, "decode each manifest document to find deprecated or removed APIs instead of matching the mapping text")
+	fs.StringVar(&s.TargetVersion, "target-version", s.TargetVersion, "Kubernetes version the cluster will be upgraded to, to map against while only mapping to APIs the cluster serves, e.g. v1.32.0")
+}
+
+// AddMapFlags binds the flags of the map command to the given flagset.
+func (s *EnvSettings) AddMapFlags(fs *pflag.FlagSet) {
+	s.AddCheckFlags(fs)
+	fs.BoolVar(&s.Diff, "diff", false, "print a unified diff of the resources changed by the mapping")
+	fs.StringVar(&s.MapOn, "map-on", string(common.MapOnDeprecated), "map APIs from the Kubernetes version they are deprecated in or removed in: deprecated or removed")
+	fs.StringVar(&s.SchemaDir, "schema-dir", s.SchemaDir, "directory with the OpenAPI schemas to validate the mapped resources against, named after the Kubernetes version, e.g. v1.29.json")
+	fs.BoolVar(&s.Validate, "validate", false, "validate the mapped resources against the OpenAPI schema of the cluster before updating the release")
 }
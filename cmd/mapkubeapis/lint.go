@@ -0,0 +1,77 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/config"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+func newLintMapfileCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint-mapfile [flags] [MAPFILE...]",
+		Short: "Check API mapping files for problems",
+		Long: `Check API mapping files for problems, such as invalid Kubernetes versions,
+API strings without a trailing line feed, duplicate or conflicting mappings,
+or mappings whose new API is the same as the deprecated API.
+
+Every problem is printed with its line number. The default mapping file, which is
+compiled into the plugin, is checked if no file is passed. The exit code is non-zero
+if a problem is found.`,
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return runLintMapfile(out, args)
+		},
+	}
+
+	return cmd
+}
+
+func runLintMapfile(out io.Writer, filenames []string) error {
+	if len(filenames) == 0 {
+		filenames = []string{mapping.DefaultMapfileName}
+	}
+	count := 0
+	for _, filename := range filenames {
+		var problems []mapping.Problem
+		if filename == mapping.DefaultMapfileName {
+			problems = mapping.Lint(config.DefaultMapfile)
+		} else {
+			var err error
+			if problems, err = mapping.LintMapfile(filename); err != nil {
+				return err
+			}
+		}
+		for _, problem := range problems {
+			if problem.Line == 0 {
+				fmt.Fprintf(out, "%s: %s\n", filename, problem.Message)
+			} else {
+				fmt.Fprintf(out, "%s:%d: %s\n", filename, problem.Line, problem.Message)
+			}
+		}
+		count += len(problems)
+	}
+	if count > 0 {
+		return fmt.Errorf("found %d problems in %d mapping files", count, len(filenames))
+	}
+	return nil
+}
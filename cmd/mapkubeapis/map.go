@@ -18,12 +18,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/helmpath"
 
 	"github.com/helm/helm-mapkubeapis/pkg/common"
 	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
@@ -31,23 +32,52 @@ import (
 
 // MapOptions contains the options for Map operation
 type MapOptions struct {
+	BackupConfigMap  bool
+	BackupDir        string
+	Diff             bool
+	Discovery        bool
 	DryRun           bool
-	MapFile          string
+	KubeVersion      string
+	MapFiles         []string
+	MapOn            common.MapOn
+	NoDefaultMapFile bool
+	Output           string
 	ReleaseName      string
 	ReleaseNamespace string
+	Revision         int
+	SchemaDir        string
+	Strict           bool
+	Structured       bool
+	TargetVersion    string
+	Validate         bool
 }
 
 var (
 	settings *EnvSettings
 )
 
-func newMapCmd(_ io.Writer) *cobra.Command {
+func newMapCmd(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:          "mapkubeapis [flags] RELEASE",
 		Short:        "Map release deprecated or removed Kubernetes APIs in-place",
 		Long:         "Map release deprecated or removed Kubernetes APIs in-place",
 		SilenceUsage: true,
 		Args: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(settings.Output); err != nil {
+				return err
+			}
+			if err := validateMapOn(settings.MapOn); err != nil {
+				return err
+			}
+			if err := validateRevision(settings.Revision, len(args), settings.All || settings.AllNamespaces); err != nil {
+				return err
+			}
+			if settings.All || settings.AllNamespaces {
+				if len(args) > 0 {
+					return errors.New("a release name may not be passed with --all or --all-namespaces")
+				}
+				return nil
+			}
 			if len(args) == 0 {
 				err := cmd.Help()
 				if err != nil {
@@ -60,20 +90,20 @@ func newMapCmd(_ io.Writer) *cobra.Command {
 			return nil
 		},
 
-		RunE: runMap,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return runMap(out, args)
+		},
 	}
 
+	cmd.CompletionOptions.DisableDefaultCmd = true
+
 	flags := cmd.PersistentFlags()
 	flags.ParseErrorsWhitelist.UnknownFlags = true
+	mapFlags := cmd.Flags()
+	mapFlags.ParseErrorsWhitelist.UnknownFlags = true
 
 	settings = new(EnvSettings)
-
-	// Get the default mapping file
-	if ctx := os.Getenv("HELM_PLUGIN_DIR"); ctx != "" {
-		settings.MapFile = filepath.Join(ctx, "config", "Map.yaml")
-	} else {
-		settings.MapFile = filepath.Join("config", "Map.yaml")
-	}
+	settings.BackupDir = helmpath.DataPath("mapkubeapis", "backup")
 
 	// When run with the Helm plugin framework, Helm plugins are not passed the
 	// plugin flags that correspond to Helm global flags e.g. helm mapkubeapis v3map --kube-context ...
@@ -88,30 +118,79 @@ func newMapCmd(_ io.Writer) *cobra.Command {
 	// the KUBECONFIG environment variable instead of being passed into the plugin.
 
 	settings.AddFlags(flags)
+	settings.AddMapFlags(mapFlags)
+
+	cmd.AddCommand(newCheckCmd(out))
+	cmd.AddCommand(newLintMapfileCmd(out))
+	cmd.AddCommand(newPrintMapfileCmd(out))
+	cmd.AddCommand(newRestoreCmd(out))
 
 	return cmd
 }
 
-func runMap(_ *cobra.Command, args []string) error {
-	releaseName := args[0]
+// validateMapOn checks that the mapping policy is supported
+func validateMapOn(mapOn string) error {
+	switch common.MapOn(mapOn) {
+	case common.MapOnDeprecated, common.MapOnRemoved:
+		return nil
+	}
+	return fmt.Errorf("invalid --map-on policy %q, must be one of: %s, %s", mapOn, common.MapOnDeprecated, common.MapOnRemoved)
+}
+
+// validateRevision checks that the release version is only set for a single release
+func validateRevision(revision int, releases int, all bool) error {
+	switch {
+	case revision < 0:
+		return fmt.Errorf("invalid --revision %d, must be a release version", revision)
+	case revision > 0 && (all || releases != 1):
+		return errors.New("--revision may only be passed with a single release name")
+	}
+	return nil
+}
+
+func runMap(out io.Writer, args []string) error {
+	mapFiles, noDefaultMapFile := settings.mapFiles()
 	mapOptions := MapOptions{
+		BackupConfigMap:  settings.BackupConfigMap,
+		BackupDir:        settings.BackupDir,
+		Diff:             settings.Diff,
+		Discovery:        settings.Discovery,
 		DryRun:           settings.DryRun,
-		MapFile:          settings.MapFile,
-		ReleaseName:      releaseName,
+		KubeVersion:      settings.KubeVersion,
+		MapFiles:         mapFiles,
+		MapOn:            common.MapOn(settings.MapOn),
+		NoDefaultMapFile: noDefaultMapFile,
+		Output:           settings.Output,
 		ReleaseNamespace: settings.Namespace,
+		Revision:         settings.Revision,
+		SchemaDir:        settings.SchemaDir,
+		Strict:           settings.Strict,
+		Structured:       settings.Structured,
+		TargetVersion:    settings.TargetVersion,
+		Validate:         settings.Validate,
 	}
-	kubeConfig := common.KubeConfig{
-		Context: settings.KubeContext,
-		File:    settings.KubeConfigFile,
+	kubeConfig := settings.KubeConfig()
+
+	if settings.All || settings.AllNamespaces {
+		return MapAll(out, mapOptions, kubeConfig, settings.AllNamespaces)
 	}
 
-	return Map(mapOptions, kubeConfig)
+	mapOptions.ReleaseName = args[0]
+	report, err := Map(mapOptions, kubeConfig)
+	if mapOptions.Output != "" {
+		if printErr := printReport(out, mapOptions.Output, report); printErr != nil {
+			return printErr
+		}
+	} else if mapOptions.Diff {
+		fmt.Fprint(out, report.Diff)
+	}
+	return err
 }
 
 // Map checks for Kubernetes deprecated or removed APIs in the manifest of the last deployed release version
 // and maps those API versions to supported versions. It then adds a new release version with
 // the updated APIs and supersedes the version with the unsupported APIs.
-func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) error {
+func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) (*common.ReleaseReport, error) {
 	if mapOptions.DryRun {
 		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
 		log.Println("Run without --dry-run to take the actions described below:")
@@ -121,18 +200,81 @@ func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) error {
 	log.Printf("Release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated if necessary to supported API versions.\n", mapOptions.ReleaseName)
 
 	options := common.MapOptions{
+		BackupConfigMap:  mapOptions.BackupConfigMap,
+		BackupDir:        mapOptions.BackupDir,
+		Diff:             mapOptions.Diff,
+		Discovery:        mapOptions.Discovery,
 		DryRun:           mapOptions.DryRun,
 		KubeConfig:       kubeConfig,
-		MapFile:          mapOptions.MapFile,
+		KubeVersion:      mapOptions.KubeVersion,
+		MapFiles:         mapOptions.MapFiles,
+		MapOn:            mapOptions.MapOn,
+		NoDefaultMapFile: mapOptions.NoDefaultMapFile,
 		ReleaseName:      mapOptions.ReleaseName,
 		ReleaseNamespace: mapOptions.ReleaseNamespace,
+		Revision:         mapOptions.Revision,
+		SchemaDir:        mapOptions.SchemaDir,
+		Strict:           mapOptions.Strict,
+		Structured:       mapOptions.Structured,
+		TargetVersion:    mapOptions.TargetVersion,
+		Validate:         mapOptions.Validate,
 	}
 
-	if err := v3.MapReleaseWithUnSupportedAPIs(options); err != nil {
-		return err
+	report, err := v3.MapRelease(options)
+	if err != nil {
+		report.Message = err.Error()
+		return report, err
 	}
 
 	log.Printf("Map of release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", mapOptions.ReleaseName)
 
+	return report, nil
+}
+
+// MapAll maps the deprecated or removed Kubernetes APIs of every release in the namespace,
+// or of every release in the cluster if allNamespaces is set. A failure to map one release
+// does not stop the others from being mapped. A summary of all releases, or the reports in the
+// output format of the map options if it is set, is written to out.
+func MapAll(out io.Writer, mapOptions MapOptions, kubeConfig common.KubeConfig, allNamespaces bool) error {
+	options := common.MapOptions{
+		DryRun:           mapOptions.DryRun,
+		KubeConfig:       kubeConfig,
+		ReleaseNamespace: mapOptions.ReleaseNamespace,
+	}
+	reports, err := v3.ForEachRelease(options, allNamespaces, func(options common.MapOptions) (*common.ReleaseReport, error) {
+		releaseOptions := mapOptions
+		releaseOptions.ReleaseName = options.ReleaseName
+		releaseOptions.ReleaseNamespace = options.ReleaseNamespace
+		report, err := Map(releaseOptions, kubeConfig)
+		if err != nil {
+			log.Printf("Failed to map release '%s' in namespace '%s': %s\n", options.ReleaseName, options.ReleaseNamespace, err)
+		}
+		if mapOptions.Diff && mapOptions.Output == "" {
+			fmt.Fprint(out, report.Diff)
+		}
+		return report, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if mapOptions.Output != "" {
+		err = printReport(out, mapOptions.Output, reports)
+	} else {
+		err = printSummary(out, reports, mapOptions.DryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, report := range reports {
+		if report.Status == common.StatusFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to map %d of %d releases", failed, len(reports))
+	}
 	return nil
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"os"
 )
 
@@ -24,6 +25,10 @@ func main() {
 	mapCmd := newMapCmd(os.Stdout)
 
 	if err := mapCmd.Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,60 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+func newPrintMapfileCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "print-mapfile [flags]",
+		Short: "Print the API mappings which are used after layering the mapping files",
+		Long: `Print the API mappings which are used after layering the mapping files.
+
+The --mapfile files are layered over the default mappings in the order they are passed.
+A mapping of a later file replaces the mapping of the same API of the files before it.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return runPrintMapfile(out)
+		},
+	}
+
+	settings.AddMapfileFlags(cmd.Flags())
+
+	return cmd
+}
+
+func runPrintMapfile(out io.Writer) error {
+	mapFiles, noDefaultMapFile := settings.mapFiles()
+	mapMetadata, err := mapping.LoadMapfiles(!noDefaultMapFile, mapFiles...)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(mapMetadata)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
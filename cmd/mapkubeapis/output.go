@@ -0,0 +1,75 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// validateOutput checks that the output format is supported
+func validateOutput(output string) error {
+	switch output {
+	case "", outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q, must be one of: %s, %s", output, outputJSON, outputYAML)
+}
+
+// printReport writes the report of one or many releases to out in the given format
+func printReport(out io.Writer, output string, report interface{}) error {
+	var b []byte
+	var err error
+	switch output {
+	case outputJSON:
+		b, err = json.MarshalIndent(report, "", "  ")
+		b = append(b, '\n')
+	case outputYAML:
+		b, err = yaml.Marshal(report)
+	default:
+		err = validateOutput(output)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(b)
+	return err
+}
+
+// printSummary writes a table with the status of each release to out
+func printSummary(out io.Writer, reports []*common.ReleaseReport, dryRun bool) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if dryRun {
+		fmt.Fprintln(w, "NOTE: This is in dry-run mode, releases with status 'mapped' have not been updated.")
+	}
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tSTATUS\tMESSAGE")
+	for _, report := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", report.Release, report.Namespace, report.Status, report.Message)
+	}
+	return w.Flush()
+}
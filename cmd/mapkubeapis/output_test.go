@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+func TestMapkubeapis(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "mapkubeapis command suite")
+}
+
+var _ = ginkgo.Describe("printing the summary of the releases", func() {
+	reports := []*common.ReleaseReport{
+		{Release: "test", Namespace: "test-ns", Status: common.StatusMapped},
+		{Release: "uninstalled", Namespace: "test-ns", Status: common.StatusSkipped, Message: "release is uninstalled"},
+		{Release: "stuck", Namespace: "other-ns", Status: common.StatusFailed, Message: "release 'stuck' has no deployed version"},
+	}
+
+	ginkgo.It("writes a table with the status of each release", func() {
+		var out bytes.Buffer
+		gomega.Expect(printSummary(&out, reports, false)).To(gomega.Succeed())
+		gomega.Expect(out.String()).To(gomega.Equal(`NAME         NAMESPACE  STATUS   MESSAGE
+test         test-ns    mapped   
+uninstalled  test-ns    skipped  release is uninstalled
+stuck        other-ns   failed   release 'stuck' has no deployed version
+`))
+	})
+
+	ginkgo.It("notes that the mapped releases are not updated in dry-run mode", func() {
+		var out bytes.Buffer
+		gomega.Expect(printSummary(&out, reports, true)).To(gomega.Succeed())
+		gomega.Expect(out.String()).To(gomega.HavePrefix("NOTE: This is in dry-run mode, releases with status 'mapped' have not been updated.\n"))
+	})
+})
@@ -0,0 +1,59 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+func newRestoreCmd(_ io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "restore [flags] RELEASE",
+		Short:        "Restore a release to the version before it was mapped",
+		Long:         "Restore a release to the version before it was mapped, using the backup taken by the mapping",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE:         runRestore,
+	}
+
+	return cmd
+}
+
+func runRestore(_ *cobra.Command, args []string) error {
+	options := common.MapOptions{
+		BackupConfigMap:  settings.BackupConfigMap,
+		BackupDir:        settings.BackupDir,
+		DryRun:           settings.DryRun,
+		KubeConfig:       settings.KubeConfig(),
+		ReleaseName:      args[0],
+		ReleaseNamespace: settings.Namespace,
+	}
+
+	if options.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+
+	return v3.RestoreRelease(options)
+}
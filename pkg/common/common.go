@@ -17,11 +17,13 @@ limitations under the License.
 package common
 
 import (
+	"fmt"
 	"log"
 	"strings"
 
 	"github.com/pkg/errors"
 	"golang.org/x/mod/semver"
+	"k8s.io/client-go/discovery"
 
 	"github.com/helm/helm-mapkubeapis/pkg/mapping"
 )
@@ -30,57 +32,196 @@ import (
 type KubeConfig struct {
 	Context string
 	File    string
+
+	// StorageDriver is the Helm storage driver of the releases: secret, configmap, sql or memory.
+	// HELM_DRIVER is used if it is not set.
+	StorageDriver string
+
+	// StorageConnection is the connection string of the sql driver, or the release files to load into
+	// the memory driver. HELM_DRIVER_SQL_CONNECTION_STRING or HELM_MEMORY_DRIVER_DATA is used if it is not set.
+	StorageConnection string
 }
 
 // MapOptions are the options for mapping deprecated APIs in a release
 type MapOptions struct {
+	BackupConfigMap  bool
+	BackupDir        string
+	Diff             bool
+	Discovery        bool
 	DryRun           bool
 	KubeConfig       KubeConfig
-	MapFile          string
+	KubeVersion      string
+	MapFiles         []string
+	MapOn            MapOn
+	NoDefaultMapFile bool
 	ReleaseName      string
 	ReleaseNamespace string
+	Revision         int
+	SchemaDir        string
+	Strict           bool
+	Structured       bool
+	TargetVersion    string
+	Validate         bool
+
+	// MapFile is a mapping file which is used instead of the default mappings, with MapFiles layered over it.
+	//
+	// Deprecated: use MapFiles with NoDefaultMapFile.
+	MapFile string
 }
 
+// MapOn is the policy of from which Kubernetes version a deprecated API is mapped
+type MapOn string
+
+const (
+	// MapOnDeprecated maps an API from the Kubernetes version it is deprecated in, or removed in if it is
+	// never deprecated
+	MapOnDeprecated MapOn = "deprecated"
+	// MapOnRemoved maps an API from the Kubernetes version it is removed in only, a deprecated API which
+	// is still served is reported but not mapped
+	MapOnRemoved MapOn = "removed"
+)
+
 // UpgradeDescription is description of why release was upgraded
 const UpgradeDescription = "Kubernetes deprecated API upgrade - DO NOT rollback from this version"
 
-// ReplaceManifestUnSupportedAPIs returns a release manifest with deprecated or removed
-// Kubernetes APIs updated to supported APIs
-func ReplaceManifestUnSupportedAPIs(origManifest, mapFile string, kubeConfig KubeConfig, additionalMappings ...*mapping.Mapping) (string, error) {
-	var modifiedManifest = origManifest
-	var err error
-	var mapMetadata *mapping.Metadata
+// ManifestMapper maps deprecated or removed Kubernetes APIs in release manifests. The mapping
+// data and the Kubernetes version are looked up once, so that the mapper can be used for all the
+// manifests of a release, such as the release manifest and the manifests of its hooks.
+type ManifestMapper struct {
+	mapMetadata    *mapping.Metadata
+	kubeVersionStr string
+	mapOn          MapOn
+	structured     bool
 
-	// Load the mapping data
-	if mapMetadata, err = mapping.LoadMapfile(mapFile); err != nil {
-		return "", errors.Wrapf(err, "Failed to load mapping file: %s", mapFile)
-	}
+	// served are the APIs served by the Kubernetes server, only set when mapping against a target
+	// version, so that APIs are not mapped to new APIs which the server does not serve yet
+	served *ServedAPIs
+}
 
-	mapMetadata.Mappings = append(mapMetadata.Mappings, additionalMappings...)
+// NewManifestMapper loads the mapping data and gets the Kubernetes server version to map against.
+// If a Kubernetes version is set in the options, it is used instead and the server is not contacted.
+// If a target version is set, it is mapped against instead of the server version, and the APIs
+// served by the server are discovered so that only new APIs the server serves are mapped to.
+// If the mapping data is not known to cover the Kubernetes version, a warning is logged, or an
+// error is returned if strict is set in the options.
+func NewManifestMapper(mapOptions MapOptions, additionalMappings ...*mapping.Mapping) (*ManifestMapper, error) {
+	mapMetadata, err := LoadMappings(mapOptions, additionalMappings...)
+	if err != nil {
+		return nil, err
+	}
 
-	// get the Kubernetes server version
-	kubeVersionStr, err := getKubernetesServerVersion(kubeConfig)
+	// the Kubernetes server is not contacted if a Kubernetes version is set
+	var client discovery.DiscoveryInterface
+	if mapOptions.KubeVersion == "" {
+		clientSet, err := GetClientSetWithKubeConfig(mapOptions.KubeConfig.File, mapOptions.KubeConfig.Context)
+		if err != nil {
+			return nil, err
+		}
+		client = clientSet.Discovery()
+	}
+	versions, served, err := NewVersionSource(mapOptions, client)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	kubeVersionStr, err := versions.KubeVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = CheckMapCoverage(mapMetadata, kubeVersionStr, mapOptions.Strict); err != nil {
+		return nil, err
+	}
+
+	mapper := NewManifestMapperFor(mapMetadata, kubeVersionStr, mapOptions.MapOn, mapOptions.Structured)
+	mapper.SetServedAPIs(served)
+	return mapper, nil
+}
+
+// NewManifestMapperFor returns a mapper of the mapping data against the Kubernetes version, which
+// neither loads mapping files nor contacts a Kubernetes server. The map-on policy defaults to deprecated.
+func NewManifestMapperFor(mapMetadata *mapping.Metadata, kubeVersionStr string, mapOn MapOn, structured bool) *ManifestMapper {
+	if mapOn == "" {
+		mapOn = MapOnDeprecated
+	}
+	return &ManifestMapper{
+		mapMetadata:    mapMetadata,
+		kubeVersionStr: kubeVersionStr,
+		mapOn:          mapOn,
+		structured:     structured,
+	}
+}
+
+// parseKubeVersion returns the Kubernetes version in semver format with a "v" prefix
+func parseKubeVersion(version string) (string, error) {
+	kubeVersionStr := version
+	if !strings.HasPrefix(kubeVersionStr, "v") {
+		kubeVersionStr = "v" + kubeVersionStr
 	}
 	if !semver.IsValid(kubeVersionStr) {
-		return "", errors.Errorf("Failed to get Kubernetes server version")
+		return "", errors.Errorf("Invalid Kubernetes version: %s", version)
 	}
+	return kubeVersionStr, nil
+}
+
+// SetServedAPIs sets the APIs served by the Kubernetes server. Resources of a deprecated API are
+// then only mapped if the server serves the new API, otherwise they are kept and reported.
+func (m *ManifestMapper) SetServedAPIs(served *ServedAPIs) {
+	m.served = served
+}
 
+// KubeVersion returns the Kubernetes version the manifests are mapped against
+func (m *ManifestMapper) KubeVersion() string {
+	return m.kubeVersionStr
+}
+
+// Map returns the manifest with deprecated or removed Kubernetes APIs updated to supported APIs,
+// and the mappings which matched resources in the manifest
+func (m *ManifestMapper) Map(manifest string) (string, []MappedAPI, error) {
 	// Check for deprecated or removed APIs and map accordingly to supported versions
-	modifiedManifest, err = ReplaceManifestData(mapMetadata, modifiedManifest, kubeVersionStr)
+	if m.structured {
+		return m.replaceManifestDocuments(manifest)
+	}
+	return m.replaceManifestData(manifest)
+}
+
+// ReplaceManifestUnSupportedAPIs returns a release manifest with deprecated or removed
+// Kubernetes APIs updated to supported APIs
+//
+// Deprecated: use ReplaceManifestAPIs, which takes the map options. The map file replaces the
+// default mapping data, as it always did.
+func ReplaceManifestUnSupportedAPIs(origManifest, mapFile string, kubeConfig KubeConfig, additionalMappings ...*mapping.Mapping) (string, error) {
+	return ReplaceManifestAPIs(origManifest, MapOptions{KubeConfig: kubeConfig, MapFile: mapFile}, additionalMappings...)
+}
+
+// ReplaceManifestAPIs returns a release manifest with deprecated or removed Kubernetes APIs
+// updated to supported APIs, with the mapping data and Kubernetes version of the map options
+func ReplaceManifestAPIs(origManifest string, mapOptions MapOptions, additionalMappings ...*mapping.Mapping) (string, error) {
+	mapper, err := NewManifestMapper(mapOptions, additionalMappings...)
 	if err != nil {
 		return "", err
 	}
 
-	return modifiedManifest, nil
+	modifiedManifest, _, err := mapper.Map(origManifest)
+	return modifiedManifest, err
 }
 
 // ReplaceManifestData scans the release manifest string for deprecated APIs in a given Kubernetes version and replaces
 // their groups and versions if there is a successor, or fully removes the manifest for that specific resource if no
 // successors exist (such as the PodSecurityPolicy API).
 func ReplaceManifestData(mapMetadata *mapping.Metadata, modifiedManifest string, kubeVersionStr string) (string, error) {
-	for _, mapping := range mapMetadata.Mappings {
+	mapper := &ManifestMapper{mapMetadata: mapMetadata, kubeVersionStr: kubeVersionStr, mapOn: MapOnDeprecated}
+	modifiedManifest, _, err := mapper.replaceManifestData(modifiedManifest)
+	return modifiedManifest, err
+}
+
+func (m *ManifestMapper) replaceManifestData(modifiedManifest string) (string, []MappedAPI, error) {
+	var mappedAPIs []MappedAPI
+	kubeVersionStr := m.kubeVersionStr
+	mappings, err := expandMappings(m.mapMetadata.Mappings, modifiedManifest)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, mapping := range mappings {
 		deprecatedAPI := mapping.DeprecatedAPI
 		supportedAPI := mapping.NewAPI
 		var apiVersionStr string
@@ -91,7 +232,7 @@ func ReplaceManifestData(mapMetadata *mapping.Metadata, modifiedManifest string,
 		}
 
 		if !semver.IsValid(apiVersionStr) {
-			return "", errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+			return "", nil, errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
 		}
 
 		if count := strings.Count(modifiedManifest, deprecatedAPI); count > 0 {
@@ -101,16 +242,53 @@ func ReplaceManifestData(mapMetadata *mapping.Metadata, modifiedManifest string,
 				// skip to next mapping
 				continue
 			}
+			if reason := m.keepReason(mapping); reason != "" {
+				logNotMapped(count, deprecatedAPI, reason)
+				mappedAPIs = append(mappedAPIs, newKeptAPI(mapping, count, kubeVersionStr, reason))
+				continue
+			}
 			if supportedAPI == "" {
 				log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nNo supported API equivalent\n", count, deprecatedAPI)
 				modifiedManifest = removeDeprecatedAPIWithoutSuccessor(count, deprecatedAPI, modifiedManifest)
 			} else {
 				log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", count, deprecatedAPI, supportedAPI)
-				modifiedManifest = strings.ReplaceAll(modifiedManifest, deprecatedAPI, supportedAPI)
+				if len(mapping.Transforms) > 0 {
+					if modifiedManifest, err = replaceManifestAPI(modifiedManifest, deprecatedAPI, supportedAPI, mapping.Transforms); err != nil {
+						return "", nil, err
+					}
+				} else {
+					modifiedManifest = strings.ReplaceAll(modifiedManifest, deprecatedAPI, supportedAPI)
+				}
 			}
+			mappedAPIs = append(mappedAPIs, newMappedAPI(mapping, count, kubeVersionStr, mappedAction(mapping)))
+		}
+	}
+	return modifiedManifest, mappedAPIs, nil
+}
+
+// isRemoved returns true if the API of the mapping is removed in the Kubernetes version
+func isRemoved(mapping *mapping.Mapping, kubeVersionStr string) bool {
+	return semver.IsValid(mapping.RemovedInVersion) && semver.Compare(mapping.RemovedInVersion, kubeVersionStr) <= 0
+}
+
+// keepReason returns why the resources of a deprecated API, which is due for mapping in the Kubernetes
+// version, are not mapped, or an empty string if they are mapped
+func (m *ManifestMapper) keepReason(mapping *mapping.Mapping) string {
+	if m.mapOn == MapOnRemoved && !isRemoved(mapping, m.kubeVersionStr) {
+		return fmt.Sprintf("the API is not removed in Kubernetes \"%s\"", m.kubeVersionStr)
+	}
+	if m.served != nil && mapping.NewAPI != "" {
+		header, err := parseAPIHeader(mapping.NewAPI)
+		if err == nil && !m.served.IsServed(header.APIVersion, header.Kind) {
+			return fmt.Sprintf("the supported API \"%s\" is not served by the Kubernetes server yet", header.APIVersion)
 		}
 	}
-	return modifiedManifest, nil
+	return ""
+}
+
+// logNotMapped logs that a deprecated API is not mapped, and why
+func logNotMapped(count int, deprecatedAPI string, reason string) {
+	log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nThe API is not mapped as %s\n", count, deprecatedAPI, reason)
 }
 
 // removeDeprecatedAPIWithoutSuccessor removes a deprecated API that has no successor specified in the mapping file.
@@ -143,15 +321,3 @@ func removeDeprecatedAPIWithoutSuccessor(count int, deprecatedAPI string, modifi
 	modifiedManifest = strings.Trim(modifiedManifest, "\n")
 	return modifiedManifest
 }
-
-func getKubernetesServerVersion(kubeConfig KubeConfig) (string, error) {
-	clientSet := GetClientSetWithKubeConfig(kubeConfig.File, kubeConfig.Context)
-	if clientSet == nil {
-		return "", errors.Errorf("kubernetes cluster unreachable")
-	}
-	kubeVersion, err := clientSet.ServerVersion()
-	if err != nil {
-		return "", errors.Wrap(err, "kubernetes cluster unreachable")
-	}
-	return kubeVersion.GitVersion, nil
-}
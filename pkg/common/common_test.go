@@ -7,6 +7,8 @@ import (
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/onsi/ginkgo/v2"
@@ -39,6 +41,19 @@ func CheckDecode(manifest string) error {
 	return nil
 }
 
+// ReplaceModes are the functions which replace the deprecated APIs of a manifest, by mapping mode
+var ReplaceModes = map[string]func(*mapping.Metadata, string, string) (string, error){
+	"text":       common.ReplaceManifestData,
+	"structured": common.ReplaceManifestDocuments,
+}
+
+// LoadMapFile writes the content to a map file in a temporary directory and loads it
+func LoadMapFile(content string) (*mapping.Metadata, error) {
+	mapFileName := filepath.Join(ginkgo.GinkgoT().TempDir(), "Map.yaml")
+	gomega.Expect(os.WriteFile(mapFileName, []byte(content), 0o600)).To(gomega.Succeed())
+	return mapping.LoadMapfile(mapFileName)
+}
+
 var _ = ginkgo.Describe("replacing deprecated APIs", ginkgo.Ordered, func() {
 	var mapFile *mapping.Metadata
 
@@ -423,3 +438,47 @@ spec:
 		})
 	})
 })
+
+var _ = ginkgo.Describe("mapping with an explicit Kubernetes version", func() {
+	var manifest = `---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: pdb-test
+  namespace: test-ns`
+
+	ginkgo.It("maps against the given version without contacting a cluster", func() {
+		mapper, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "1.25.0",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		modifiedManifest, mappedAPIs, err := mapper.Map(manifest)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: policy/v1\nkind: PodDisruptionBudget\n"))
+		gomega.Expect(mappedAPIs).To(gomega.HaveLen(1))
+		gomega.Expect(mappedAPIs[0].DeprecatedAPI).To(gomega.Equal("apiVersion: policy/v1beta1\nkind: PodDisruptionBudget\n"))
+		gomega.Expect(mappedAPIs[0].Count).To(gomega.Equal(1))
+		gomega.Expect(mappedAPIs[0].Action).To(gomega.Equal(common.ActionReplaced))
+	})
+
+	ginkgo.It("rejects an invalid version", func() {
+		_, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "latest",
+		})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("only fails on a version the map file does not cover in strict mode", func() {
+		_, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "v1.99.0",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		_, err = common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "v1.99.0",
+			Strict:      true,
+		})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("but cluster is v1.99")))
+	})
+})
@@ -0,0 +1,245 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// resourceID identifies a resource of a manifest, so that the original and the mapped
+// version of the resource can be compared
+type resourceID struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+}
+
+// ManifestDiff returns a unified diff of every resource that differs between the original and the
+// modified manifest. Resources are paired by kind and name, so a resource which was removed from the
+// manifest shows as fully deleted. The label is used as a prefix for the resource names in the diff.
+// A resource which was re-encoded to transform its fields, which changes its formatting, is noted
+// in a line before its diff.
+func ManifestDiff(label, origManifest, modifiedManifest string) string {
+	origDocuments := splitManifestDocuments(origManifest)
+	modifiedDocuments := splitManifestDocuments(modifiedManifest)
+
+	// index the modified documents by resource, keeping the order of duplicates
+	modifiedByID := make(map[string][]*manifestDocument)
+	for _, document := range modifiedDocuments {
+		id := documentID(document)
+		modifiedByID[id] = append(modifiedByID[id], document)
+	}
+
+	var sb strings.Builder
+	for _, origDocument := range origDocuments {
+		id := documentID(origDocument)
+		fromFile := fmt.Sprintf("a/%s/%s", label, id)
+		toFile := fmt.Sprintf("b/%s/%s", label, id)
+
+		var modifiedLines []string
+		var note string
+		if candidates := modifiedByID[id]; len(candidates) > 0 {
+			modifiedByID[id] = candidates[1:]
+			// most resources are not touched by a mapping, so they are not diffed at all
+			if candidates[0].raw == origDocument.raw {
+				continue
+			}
+			modifiedLines = documentLines(candidates[0])
+			if candidates[0].isEncoded() && !origDocument.isEncoded() {
+				note = fmt.Sprintf("# %s/%s was re-encoded to transform its fields, its indentation and quoting may have changed\n", label, id)
+			}
+		} else {
+			toFile = "/dev/null"
+		}
+		if diff := unifiedDiff(fromFile, toFile, documentLines(origDocument), modifiedLines); diff != "" {
+			sb.WriteString(note + diff)
+		}
+	}
+
+	// resources are never added by a mapping, but show them if they are
+	for _, document := range modifiedDocuments {
+		id := documentID(document)
+		for _, candidate := range modifiedByID[id] {
+			if candidate == document {
+				sb.WriteString(unifiedDiff("/dev/null", fmt.Sprintf("b/%s/%s", label, id), nil, documentLines(document)))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// documentID returns the kind and name of the resource in the document
+func documentID(document *manifestDocument) string {
+	var id resourceID
+	if err := yaml.Unmarshal([]byte(document.raw), &id); err != nil || id.Kind == "" {
+		return "unknown"
+	}
+	return id.Kind + "/" + id.Metadata.Name
+}
+
+// documentLines returns the lines of the document, without the document separator and line endings
+func documentLines(document *manifestDocument) []string {
+	raw := strings.ReplaceAll(document.raw, "\r\n", "\n")
+	lines := strings.Split(strings.Trim(raw, "\n"), "\n")
+	if len(lines) > 0 && isDocumentSeparator(lines[0]) {
+		lines = lines[1:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+// diffLine is a line of a diff, prefixed with ' ', '-' or '+'
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the line operations to turn a into b, using the longest common subsequence. The lines
+// which a and b start and end with are kept as they are, so that the table of the longest common subsequence
+// only covers the lines in between, which is small for the few lines a mapping changes.
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	lines = append(lines, diffMiddleLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// diffMiddleLines returns the line operations to turn a into b from the table of their longest common subsequence
+func diffMiddleLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
+
+// unifiedDiff returns the unified diff of a and b, or an empty string if they are equal
+func unifiedDiff(fromFile, toFile string, a, b []string) string {
+	lines := diffLines(a, b)
+
+	var changes []int
+	for i, line := range lines {
+		if line.op != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromFile, toFile)
+	for c := 0; c < len(changes); {
+		// group the changes which are close enough to share their context
+		start := max(changes[c]-diffContext, 0)
+		end := changes[c]
+		for c < len(changes) && changes[c] <= end+2*diffContext {
+			end = changes[c]
+			c++
+		}
+		end = min(end+diffContext, len(lines)-1)
+
+		// line numbers of the hunk in a and b
+		aStart, bStart := 1, 1
+		for _, line := range lines[:start] {
+			if line.op != '+' {
+				aStart++
+			}
+			if line.op != '-' {
+				bStart++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, line := range lines[start : end+1] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, line := range lines[start : end+1] {
+			sb.WriteByte(line.op)
+			sb.WriteString(line.text)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
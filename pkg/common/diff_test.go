@@ -0,0 +1,125 @@
+package common_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("diffing mapped manifests", func() {
+	var origManifest = `---
+apiVersion: apps/v1beta2
+kind: Deployment
+metadata:
+  name: test
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: test-sa
+---
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: test-psp
+`
+
+	ginkgo.It("shows rewritten and removed resources only", func() {
+		modifiedManifest := `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: test-sa
+`
+		expected := `--- a/manifest/Deployment/test
++++ b/manifest/Deployment/test
+@@ -1,4 +1,4 @@
+-apiVersion: apps/v1beta2
++apiVersion: apps/v1
+ kind: Deployment
+ metadata:
+   name: test
+--- a/manifest/PodSecurityPolicy/test-psp
++++ /dev/null
+@@ -1,4 +0,0 @@
+-apiVersion: policy/v1beta1
+-kind: PodSecurityPolicy
+-metadata:
+-  name: test-psp
+`
+		gomega.Expect(common.ManifestDiff("manifest", origManifest, modifiedManifest)).To(gomega.Equal(expected))
+	})
+
+	ginkgo.It("is empty when nothing changed", func() {
+		gomega.Expect(common.ManifestDiff("manifest", origManifest, origManifest)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("notes the resources which were re-encoded to transform their fields", func() {
+		mapFile, err := mapping.LoadMapfile("../../config/Map.yaml")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		ingress := `---
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: test
+  annotations:
+    example.com/path: '/'
+spec:
+  backend:
+    serviceName: default
+    servicePort: 80
+  tls:
+  - hosts: ["example.com"]
+`
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, ingress+origManifest, "v1.22")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		diff := common.ManifestDiff("manifest", ingress+origManifest, modifiedManifest)
+		gomega.Expect(diff).To(gomega.HavePrefix("# manifest/Ingress/test was re-encoded to transform its fields, " +
+			"its indentation and quoting may have changed\n--- a/manifest/Ingress/test\n"))
+		gomega.Expect(strings.Count(diff, "re-encoded")).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("only compares the changed lines of large resources", func() {
+		var data strings.Builder
+		for i := 0; i < 20000; i++ {
+			fmt.Fprintf(&data, "  key-%d: value-%d\n", i, i)
+		}
+		configMap := "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: large\ndata:\n" + data.String()
+		deployment := "---\napiVersion: apps/v1beta2\nkind: Deployment\nmetadata:\n  name: large\nspec:\n" + data.String()
+		mappedDeployment := strings.Replace(deployment, "apps/v1beta2", "apps/v1", 1)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		diff := common.ManifestDiff("manifest", configMap+deployment, configMap+mappedDeployment)
+		runtime.ReadMemStats(&after)
+
+		gomega.Expect(diff).To(gomega.Equal(`--- a/manifest/Deployment/large
++++ b/manifest/Deployment/large
+@@ -1,4 +1,4 @@
+-apiVersion: apps/v1beta2
++apiVersion: apps/v1
+ kind: Deployment
+ metadata:
+   name: large
+`))
+		// decoding the resources takes some memory, but a table of the longest common subsequence of
+		// either resource would take over 3 GB
+		gomega.Expect(after.TotalAlloc - before.TotalAlloc).To(gomega.BeNumerically("<", 512<<20))
+	})
+})
@@ -0,0 +1,136 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+)
+
+// ServedAPIs are the API group versions and kinds which a Kubernetes cluster serves
+type ServedAPIs struct {
+	// kinds are the kinds served by each group version
+	kinds map[string]map[string]bool
+	// groupVersions are the group versions which serve each kind
+	groupVersions map[string][]string
+}
+
+// NewServedAPIs returns the served APIs of the discovered API resource lists
+func NewServedAPIs(resourceLists []*metav1.APIResourceList) *ServedAPIs {
+	served := &ServedAPIs{
+		kinds:         make(map[string]map[string]bool),
+		groupVersions: make(map[string][]string),
+	}
+	for _, resourceList := range resourceLists {
+		if resourceList == nil {
+			continue
+		}
+		groupVersion := resourceList.GroupVersion
+		for _, resource := range resourceList.APIResources {
+			// subresources, such as deployments/scale, are not resources of a manifest
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			if served.kinds[groupVersion] == nil {
+				served.kinds[groupVersion] = make(map[string]bool)
+			}
+			if !served.kinds[groupVersion][resource.Kind] {
+				served.kinds[groupVersion][resource.Kind] = true
+				served.groupVersions[resource.Kind] = append(served.groupVersions[resource.Kind], groupVersion)
+			}
+		}
+	}
+	return served
+}
+
+// DiscoverServedAPIs returns the APIs served by the cluster. Groups which fail discovery, such
+// as an aggregated API whose server is down, are logged and treated as not served.
+func DiscoverServedAPIs(client discovery.DiscoveryInterface) (*ServedAPIs, error) {
+	_, resourceLists, err := client.ServerGroupsAndResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, errors.Wrap(err, "Failed to discover the APIs served by the Kubernetes server")
+		}
+		log.Printf("Some APIs could not be discovered and are treated as not served: %s\n", err)
+	}
+	return NewServedAPIs(resourceLists), nil
+}
+
+// IsServed returns true if the cluster serves the kind in the API version
+func (s *ServedAPIs) IsServed(apiVersion, kind string) bool {
+	return s.kinds[apiVersion][kind]
+}
+
+// Suggest returns the API versions which the cluster serves for the kind. The versions of
+// the same group as the given API version come first, and the newest version of each group
+// comes first within the group.
+func (s *ServedAPIs) Suggest(apiVersion, kind string) []string {
+	group := parseGroupVersion(apiVersion).Group
+	suggestions := append([]string(nil), s.groupVersions[kind]...)
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		gvI, gvJ := parseGroupVersion(suggestions[i]), parseGroupVersion(suggestions[j])
+		if sameGroupI, sameGroupJ := gvI.Group == group, gvJ.Group == group; sameGroupI != sameGroupJ {
+			return sameGroupI
+		}
+		if gvI.Group != gvJ.Group {
+			return gvI.Group < gvJ.Group
+		}
+		return version.CompareKubeAwareVersionStrings(gvI.Version, gvJ.Version) > 0
+	})
+	return suggestions
+}
+
+// Unsupported returns the resources of the manifest whose API the cluster does not serve
+func (s *ServedAPIs) Unsupported(manifest string) []UnsupportedAPI {
+	var unsupported []UnsupportedAPI
+	for _, document := range splitManifestDocuments(manifest) {
+		if document.apiVersion == nil || document.kind == nil {
+			continue
+		}
+		apiVersion, kind := document.apiVersion.Value, document.kind.Value
+		if s.IsServed(apiVersion, kind) {
+			continue
+		}
+		var id resourceID
+		_ = yaml.Unmarshal([]byte(document.raw), &id)
+		unsupported = append(unsupported, UnsupportedAPI{
+			APIVersion:           apiVersion,
+			Kind:                 kind,
+			Name:                 id.Metadata.Name,
+			SuggestedAPIVersions: s.Suggest(apiVersion, kind),
+		})
+	}
+	return unsupported
+}
+
+// parseGroupVersion returns the group and version of an API version, or an empty
+// group version if it is not valid
+func parseGroupVersion(apiVersion string) schema.GroupVersion {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return schema.GroupVersion{}
+	}
+	return gv
+}
@@ -0,0 +1,69 @@
+package common_test
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("finding resources of APIs the cluster does not serve", func() {
+	var served = common.NewServedAPIs([]*metav1.APIResourceList{
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment"},
+				{Name: "deployments/scale", Kind: "Scale"},
+			},
+		},
+		{
+			GroupVersion: "networking.k8s.io/v1",
+			APIResources: []metav1.APIResource{{Name: "ingresses", Kind: "Ingress"}},
+		},
+		{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{{Name: "ingresses", Kind: "Ingress"}},
+		},
+		{
+			GroupVersion: "example.com/v2",
+			APIResources: []metav1.APIResource{{Name: "ingresses", Kind: "Ingress"}},
+		},
+	})
+
+	ginkgo.It("reports the unserved resources with the served versions of their kind", func() {
+		unsupported := served.Unsupported(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: served
+---
+apiVersion: example.com/v1beta1
+kind: Ingress
+metadata:
+  name: custom
+---
+apiVersion: apps/v1
+kind: Scale
+metadata:
+  name: subresource
+---
+# Source: empty.yaml
+`)
+
+		gomega.Expect(unsupported).To(gomega.Equal([]common.UnsupportedAPI{
+			{
+				APIVersion:           "example.com/v1beta1",
+				Kind:                 "Ingress",
+				Name:                 "custom",
+				SuggestedAPIVersions: []string{"example.com/v2", "example.com/v1", "networking.k8s.io/v1"},
+			},
+			{
+				APIVersion: "apps/v1",
+				Kind:       "Scale",
+				Name:       "subresource",
+			},
+		}))
+	})
+})
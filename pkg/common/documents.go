@@ -0,0 +1,289 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// apiHeader is the apiVersion and kind of a Kubernetes resource
+type apiHeader struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+// manifestDocument is a single YAML document of a release manifest. The raw text, including
+// the leading document separator, is kept so that the document can be written back byte-for-byte.
+type manifestDocument struct {
+	raw        string
+	apiVersion *yaml.Node
+	kind       *yaml.Node
+}
+
+// ReplaceManifestDocuments scans the release manifest for deprecated APIs in a given Kubernetes version like
+// ReplaceManifestData, but decodes each YAML document of the manifest instead of searching for the literal
+// mapping text. This finds resources regardless of key order, comments, quoting or line endings. Only the
+// apiVersion and kind values of a matched document are rewritten, the rest of the manifest is kept as is.
+func ReplaceManifestDocuments(mapMetadata *mapping.Metadata, modifiedManifest string, kubeVersionStr string) (string, error) {
+	mapper := &ManifestMapper{mapMetadata: mapMetadata, kubeVersionStr: kubeVersionStr, mapOn: MapOnDeprecated}
+	modifiedManifest, _, err := mapper.replaceManifestDocuments(modifiedManifest)
+	return modifiedManifest, err
+}
+
+func (m *ManifestMapper) replaceManifestDocuments(modifiedManifest string) (string, []MappedAPI, error) {
+	var mappedAPIs []MappedAPI
+	kubeVersionStr := m.kubeVersionStr
+	documents := splitManifestDocuments(modifiedManifest)
+
+	mappings, err := expandMappings(m.mapMetadata.Mappings, modifiedManifest)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, mapping := range mappings {
+		deprecatedAPI := mapping.DeprecatedAPI
+		supportedAPI := mapping.NewAPI
+		var apiVersionStr string
+		if mapping.DeprecatedInVersion != "" {
+			apiVersionStr = mapping.DeprecatedInVersion
+		} else {
+			apiVersionStr = mapping.RemovedInVersion
+		}
+
+		if !semver.IsValid(apiVersionStr) {
+			return "", nil, errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+		}
+
+		deprecatedHeader, err := parseAPIHeader(deprecatedAPI)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "Failed to parse the deprecated API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+		}
+		var supportedHeader apiHeader
+		if supportedAPI != "" {
+			if supportedHeader, err = parseAPIHeader(supportedAPI); err != nil {
+				return "", nil, errors.Wrapf(err, "Failed to parse the supported API: %s", strings.ReplaceAll(supportedAPI, "\n", " "))
+			}
+		}
+
+		count := 0
+		for _, document := range documents {
+			if document.matches(deprecatedHeader) {
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		if semver.Compare(apiVersionStr, kubeVersionStr) > 0 {
+			log.Printf("The following API:\n\"%s\" does not require mapping as the "+
+				"API is not deprecated or removed in Kubernetes \"%s\"\n", deprecatedAPI, kubeVersionStr)
+			// skip to next mapping
+			continue
+		}
+		if reason := m.keepReason(mapping); reason != "" {
+			logNotMapped(count, deprecatedAPI, reason)
+			mappedAPIs = append(mappedAPIs, newKeptAPI(mapping, count, kubeVersionStr, reason))
+			continue
+		}
+
+		var mappedDocuments []*manifestDocument
+		if supportedAPI == "" {
+			log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nNo supported API equivalent\n", count, deprecatedAPI)
+		} else {
+			log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", count, deprecatedAPI, supportedAPI)
+		}
+		for _, document := range documents {
+			if !document.matches(deprecatedHeader) {
+				mappedDocuments = append(mappedDocuments, document)
+				continue
+			}
+			if supportedAPI == "" {
+				// drop the resource as there is no successor
+				continue
+			}
+			if err := document.transform(mapping.Transforms); err != nil {
+				return "", nil, errors.Wrapf(err, "Failed to transform the fields of API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+			}
+			if err := document.setHeader(supportedHeader); err != nil {
+				return "", nil, err
+			}
+			mappedDocuments = append(mappedDocuments, document)
+		}
+		documents = mappedDocuments
+		mappedAPIs = append(mappedAPIs, newMappedAPI(mapping, count, kubeVersionStr, mappedAction(mapping)))
+	}
+
+	var sb strings.Builder
+	for _, document := range documents {
+		sb.WriteString(document.raw)
+	}
+	return sb.String(), mappedAPIs, nil
+}
+
+// parseAPIHeader decodes the apiVersion and kind from a mapping API string
+func parseAPIHeader(api string) (apiHeader, error) {
+	var header apiHeader
+	if err := yaml.Unmarshal([]byte(api), &header); err != nil {
+		return header, err
+	}
+	if header.APIVersion == "" || header.Kind == "" {
+		return header, errors.New("apiVersion and kind are required")
+	}
+	return header, nil
+}
+
+// splitManifestDocuments splits a manifest into its YAML documents. Each document keeps the
+// separator line that precedes it, so joining the documents gives back the original manifest.
+func splitManifestDocuments(manifest string) []*manifestDocument {
+	var documents []*manifestDocument
+	var current strings.Builder
+	for _, line := range strings.SplitAfter(manifest, "\n") {
+		if isDocumentSeparator(line) && current.Len() > 0 {
+			documents = append(documents, newManifestDocument(current.String()))
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		documents = append(documents, newManifestDocument(current.String()))
+	}
+	return documents
+}
+
+// isDocumentSeparator returns true if the line is a YAML document start marker
+func isDocumentSeparator(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	return line == "---" || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "---\t")
+}
+
+func newManifestDocument(raw string) *manifestDocument {
+	document := &manifestDocument{raw: raw}
+	document.decodeHeader()
+	return document
+}
+
+// decodeHeader looks up the top-level apiVersion and kind nodes of the document. Documents
+// which cannot be decoded are left without a header and are therefore never mapped.
+func (d *manifestDocument) decodeHeader() {
+	d.apiVersion, d.kind = nil, nil
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(d.raw), &root); err != nil {
+		return
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return
+	}
+	content := root.Content[0].Content
+	for i := 0; i+1 < len(content); i += 2 {
+		value := content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+		switch content[i].Value {
+		case "apiVersion":
+			d.apiVersion = value
+		case "kind":
+			d.kind = value
+		}
+	}
+}
+
+// matches returns true if the document is a resource of the given API
+func (d *manifestDocument) matches(header apiHeader) bool {
+	return d.apiVersion != nil && d.kind != nil &&
+		d.apiVersion.Value == header.APIVersion && d.kind.Value == header.Kind
+}
+
+// setHeader rewrites the apiVersion and kind values of the document in place
+func (d *manifestDocument) setHeader(header apiHeader) error {
+	// Rewrite the node which appears later in the document first, so that the
+	// position of the other node stays valid.
+	first, second := d.apiVersion, d.kind
+	firstValue, secondValue := header.APIVersion, header.Kind
+	if first.Line < second.Line || (first.Line == second.Line && first.Column < second.Column) {
+		first, second = second, first
+		firstValue, secondValue = secondValue, firstValue
+	}
+
+	raw, err := replaceScalar(d.raw, first, firstValue)
+	if err != nil {
+		return err
+	}
+	if raw, err = replaceScalar(raw, second, secondValue); err != nil {
+		return err
+	}
+	d.raw = raw
+	d.decodeHeader()
+	return nil
+}
+
+// replaceScalar replaces the text of a single line scalar node with the given value,
+// keeping the quoting style of the original value.
+func replaceScalar(raw string, node *yaml.Node, value string) (string, error) {
+	if node.Value == value {
+		return raw, nil
+	}
+
+	// find the start of the line the node is on
+	start := 0
+	for line := 1; line < node.Line; line++ {
+		next := strings.IndexByte(raw[start:], '\n')
+		if next == -1 {
+			return "", errors.Errorf("Failed to locate value %q in manifest", node.Value)
+		}
+		start += next + 1
+	}
+
+	// columns are counted in characters, not bytes
+	for column := 1; column < node.Column; column++ {
+		if start >= len(raw) || raw[start] == '\n' {
+			return "", errors.Errorf("Failed to locate value %q in manifest", node.Value)
+		}
+		_, size := utf8.DecodeRuneInString(raw[start:])
+		start += size
+	}
+
+	end := start
+	replacement := value
+	if start < len(raw) && (raw[start] == '"' || raw[start] == '\'') {
+		quote := raw[start]
+		closing := strings.IndexByte(raw[start+1:], quote)
+		if closing == -1 {
+			return "", errors.Errorf("Failed to locate value %q in manifest", node.Value)
+		}
+		end = start + 1 + closing + 1
+		replacement = string(quote) + value + string(quote)
+	} else {
+		for end < len(raw) && !strings.ContainsRune(" \t\r\n", rune(raw[end])) {
+			end++
+		}
+	}
+
+	if end == start {
+		return "", errors.Errorf("Failed to locate value %q in manifest", node.Value)
+	}
+	return raw[:start] + replacement + raw[end:], nil
+}
@@ -0,0 +1,143 @@
+package common_test
+
+import (
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("replacing deprecated APIs in manifest documents", ginkgo.Ordered, func() {
+	var mapFile *mapping.Metadata
+	var kubeVersion125 = "v1.25"
+
+	ginkgo.BeforeAll(func() {
+		mapFile = &mapping.Metadata{
+			Mappings: []*mapping.Mapping{
+				{
+					DeprecatedAPI:       "apiVersion: apps/v1beta2\nkind: Deployment\n",
+					NewAPI:              "apiVersion: apps/v1\nkind: Deployment\n",
+					DeprecatedInVersion: "v1.9",
+					RemovedInVersion:    "v1.16",
+				},
+				{
+					DeprecatedAPI:    "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\n",
+					RemovedInVersion: "v1.25",
+				},
+				{
+					DeprecatedAPI:       "apiVersion: networking.k8s.io/v1beta1\nkind: Ingress\n",
+					NewAPI:              "apiVersion: networking.k8s.io/v1\nkind: Ingress\n",
+					DeprecatedInVersion: "v1.19",
+					RemovedInVersion:    "v1.22",
+				},
+			},
+		}
+	})
+
+	ginkgo.It("maps a resource where kind comes before apiVersion", func() {
+		manifest := `---
+# Source: test/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: test
+apiVersion: apps/v1beta2
+spec:
+  replicas: 1
+`
+		expected := `---
+# Source: test/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: test
+apiVersion: apps/v1
+spec:
+  replicas: 1
+`
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, manifest, kubeVersion125)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(expected))
+	})
+
+	ginkgo.It("keeps quoting, comments and whitespace of the mapped resource", func() {
+		manifest := `---
+apiVersion:   "networking.k8s.io/v1beta1" # pinned by vendor
+kind: 'Ingress'
+metadata:
+  name: test   # keep me
+`
+		expected := `---
+apiVersion:   "networking.k8s.io/v1" # pinned by vendor
+kind: 'Ingress'
+metadata:
+  name: test   # keep me
+`
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, manifest, kubeVersion125)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(expected))
+	})
+
+	ginkgo.It("maps resources with CRLF line endings", func() {
+		manifest := "---\r\napiVersion: apps/v1beta2\r\nkind: Deployment\r\nmetadata:\r\n  name: test\r\n"
+		expected := "---\r\napiVersion: apps/v1\r\nkind: Deployment\r\nmetadata:\r\n  name: test\r\n"
+
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, manifest, kubeVersion125)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(expected))
+	})
+
+	ginkgo.It("removes resources without a successor and keeps the other documents unchanged", func() {
+		manifest := `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: test-sa
+---
+metadata:
+  name: test-psp
+kind: PodSecurityPolicy
+apiVersion: policy/v1beta1
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  apiVersion: policy/v1beta1
+  kind: PodSecurityPolicy
+`
+		expected := `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: test-sa
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  apiVersion: policy/v1beta1
+  kind: PodSecurityPolicy
+`
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, manifest, kubeVersion125)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(expected))
+
+		err = CheckDecode(modifiedManifest)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("does not map APIs which are not deprecated in the Kubernetes version", func() {
+		manifest := `---
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: test
+`
+		modifiedManifest, err := common.ReplaceManifestDocuments(mapFile, manifest, "v1.18")
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(manifest))
+	})
+})
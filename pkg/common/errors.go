@@ -0,0 +1,92 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// KubeConfigNotFoundError is returned when none of the kubeconfig files exist
+type KubeConfigNotFoundError struct {
+	// Files are the kubeconfig files which were looked for
+	Files []string
+}
+
+func (e *KubeConfigNotFoundError) Error() string {
+	return fmt.Sprintf("kubeconfig not found, looked for: %s", strings.Join(e.Files, ", "))
+}
+
+// ContextNotFoundError is returned when the kubeconfig has no context of the given name
+type ContextNotFoundError struct {
+	// Context is the name of the context
+	Context string
+}
+
+func (e *ContextNotFoundError) Error() string {
+	return fmt.Sprintf("context \"%s\" not found in the kubeconfig", e.Context)
+}
+
+// AuthError is returned when the Kubernetes server rejects the credentials of the kubeconfig
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("kubernetes cluster authentication failed: %s", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// UnreachableError is returned when the Kubernetes server cannot be reached. The error may be
+// temporary, such as while the control plane is being upgraded, so the call can be retried.
+type UnreachableError struct {
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("kubernetes cluster unreachable: %s", e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// serverError returns the error of a request to the Kubernetes server as an AuthError or an
+// UnreachableError if it is one, or else the error unchanged
+func serverError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return &AuthError{Err: err}
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
+		return &UnreachableError{Err: err}
+	}
+	return err
+}
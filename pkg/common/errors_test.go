@@ -0,0 +1,100 @@
+package common_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("connecting to the Kubernetes server", func() {
+	writeKubeConfig := func(server string) string {
+		kubeConfigFile := filepath.Join(ginkgo.GinkgoT().TempDir(), "config")
+		gomega.Expect(os.WriteFile(kubeConfigFile, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+  - name: test
+    cluster:
+      server: %s
+contexts:
+  - name: test
+    context:
+      cluster: test
+      user: test
+current-context: test
+users:
+  - name: test
+    user:
+      token: test
+`, server)), 0o600)).To(gomega.Succeed())
+		return kubeConfigFile
+	}
+
+	ginkgo.It("returns an error when the kubeconfig is not found", func() {
+		missing := filepath.Join(ginkgo.GinkgoT().TempDir(), "missing")
+		_, err := common.GetClientSetWithKubeConfig(missing, "")
+		var notFound *common.KubeConfigNotFoundError
+		gomega.Expect(errors.As(err, &notFound)).To(gomega.BeTrue())
+		gomega.Expect(notFound.Files).To(gomega.Equal([]string{missing}))
+	})
+
+	ginkgo.It("returns an error when the context is not found", func() {
+		_, err := common.GetClientSetWithKubeConfig(writeKubeConfig("https://127.0.0.1:6443"), "other")
+		var notFound *common.ContextNotFoundError
+		gomega.Expect(errors.As(err, &notFound)).To(gomega.BeTrue())
+		gomega.Expect(notFound.Context).To(gomega.Equal("other"))
+	})
+
+	ginkgo.It("returns an error when the server rejects the credentials", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`))
+		}))
+		defer server.Close()
+
+		_, err := common.NewManifestMapper(common.MapOptions{
+			KubeConfig: common.KubeConfig{File: writeKubeConfig(server.URL)},
+		})
+		var authErr *common.AuthError
+		gomega.Expect(errors.As(err, &authErr)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("returns an error when the server is unreachable", func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		_, err := common.NewManifestMapper(common.MapOptions{
+			KubeConfig: common.KubeConfig{File: writeKubeConfig(server.URL)},
+		})
+		var unreachable *common.UnreachableError
+		gomega.Expect(errors.As(err, &unreachable)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("connects with the Helm environment settings", func() {
+		var authorization string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"major":"1","minor":"22","gitVersion":"v1.22.0"}`))
+		}))
+		defer server.Close()
+
+		ginkgo.GinkgoT().Setenv("KUBECONFIG", filepath.Join(ginkgo.GinkgoT().TempDir(), "missing"))
+		ginkgo.GinkgoT().Setenv("HELM_KUBEAPISERVER", server.URL)
+		ginkgo.GinkgoT().Setenv("HELM_KUBETOKEN", "env-token")
+		ginkgo.GinkgoT().Setenv("HELM_KUBEINSECURE_SKIP_TLS_VERIFY", "true")
+
+		mapper, err := common.NewManifestMapper(common.MapOptions{})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(mapper.KubeVersion()).To(gomega.Equal("v1.22.0"))
+		gomega.Expect(authorization).To(gomega.Equal("Bearer env-token"))
+	})
+})
@@ -0,0 +1,126 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// expandMappings returns the mappings with the DeprecatedAPI and NewAPI strings set for structured
+// rules. A rule with a wildcard kind is expanded to one mapping for each kind in the manifest, except
+// the kinds which have their own mapping, either a structured rule or a deprecatedAPI string. The
+// mappings are ordered so that chained mappings are applied one after the other.
+func expandMappings(mappings []*mapping.Mapping, manifest string) ([]*mapping.Mapping, error) {
+	concrete := make(map[string]bool)
+	for _, m := range mappings {
+		if !m.IsWildcard() {
+			concrete[m.DeprecatedAPIKey()] = true
+		}
+	}
+
+	var kinds []string
+	var expanded []*mapping.Mapping
+	for _, m := range mappings {
+		if !m.IsStructured() {
+			expanded = append(expanded, m)
+			continue
+		}
+		if m.Version == "" {
+			return nil, errors.Errorf("Failed to get the API version for mapping of kind %s in group \"%s\"", m.Kind, m.Group)
+		}
+		if !m.IsWildcard() {
+			expanded = append(expanded, m.ForKind(m.Kind))
+			continue
+		}
+		if kinds == nil {
+			kinds = manifestKinds(manifest)
+		}
+		for _, kind := range kinds {
+			if forKind := m.ForKind(kind); !concrete[forKind.DeprecatedAPIKey()] {
+				expanded = append(expanded, forKind)
+			}
+		}
+	}
+	return orderMappings(expanded)
+}
+
+// orderMappings returns the mappings ordered so that a mapping to an API comes before the mapping
+// from that API, such as v1beta1 to v1beta2 before v1beta2 to v1. A resource is then mapped along
+// the chain in one run, up to the newest API which the Kubernetes version allows. Mappings which
+// do not depend on each other keep their order in the map file.
+func orderMappings(mappings []*mapping.Mapping) ([]*mapping.Mapping, error) {
+	from := make([]string, len(mappings))
+	to := make([]string, len(mappings))
+	for i, m := range mappings {
+		from[i], to[i] = m.DeprecatedAPIKey(), m.NewAPIKey()
+	}
+
+	// the number of mappings to the API of each mapping, which must be applied before it
+	pending := make([]int, len(mappings))
+	for i := range mappings {
+		for j := range mappings {
+			if i != j && to[j] != "" && to[j] == from[i] {
+				pending[i]++
+			}
+		}
+	}
+
+	ordered := make([]*mapping.Mapping, 0, len(mappings))
+	done := make([]bool, len(mappings))
+	for len(ordered) < len(mappings) {
+		next := -1
+		for i := range mappings {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			for i := range mappings {
+				if !done[i] {
+					return nil, errors.Errorf("Failed to order the mappings as they form a cycle through API: %s", from[i])
+				}
+			}
+		}
+		done[next] = true
+		ordered = append(ordered, mappings[next])
+		for i := range mappings {
+			if !done[i] && to[next] != "" && to[next] == from[i] {
+				pending[i]--
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// manifestKinds returns the sorted kinds of the resources in the manifest
+func manifestKinds(manifest string) []string {
+	seen := make(map[string]bool)
+	kinds := []string{}
+	for _, document := range splitManifestDocuments(manifest) {
+		if document.kind != nil && !seen[document.kind.Value] {
+			seen[document.kind.Value] = true
+			kinds = append(kinds, document.kind.Value)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
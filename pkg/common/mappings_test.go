@@ -0,0 +1,169 @@
+package common_test
+
+import (
+	"strings"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("replacing deprecated APIs with structured mapping rules", ginkgo.Ordered, func() {
+	var mapFile *mapping.Metadata
+	var kubeVersion125 = "v1.25"
+
+	var manifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: test
+---
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: test
+---
+apiVersion: example.com/v1alpha1
+kind: Widget
+metadata:
+  name: test
+---
+apiVersion: example.com/v1alpha1
+kind: Gadget
+metadata:
+  name: test
+`
+
+	ginkgo.BeforeAll(func() {
+		var err error
+		mapFile, err = LoadMapFile(`mappings:
+  - deprecatedAPI: "apiVersion: extensions/v1beta1\nkind: DaemonSet\n"
+    newAPI: "apiVersion: apps/v1\nkind: DaemonSet\n"
+    deprecatedInVersion: "v1.9"
+    removedInVersion: "v1.16"
+  - group: extensions
+    version: v1beta1
+    kind: "*"
+    newGroup: apps
+    newVersion: v1
+    removedInVersion: "v1.16"
+  - group: example.com
+    version: v1alpha1
+    kind: Widget
+    newVersion: v1
+    removedInVersion: "v1.20"
+  - group: example.com
+    version: v1alpha1
+    kind: Gadget
+    removedInVersion: "v1.20"
+`)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("loads both the string and the structured form", func() {
+		gomega.Expect(mapFile.Mappings).To(gomega.HaveLen(4))
+		gomega.Expect(mapFile.Mappings[0].IsStructured()).To(gomega.BeFalse())
+		gomega.Expect(mapFile.Mappings[1].IsWildcard()).To(gomega.BeTrue())
+		gomega.Expect(mapFile.Mappings[2].NewAPIVersion()).To(gomega.Equal("example.com/v1"))
+	})
+
+	for mode, replace := range ReplaceModes {
+		ginkgo.It("maps concrete and wildcard kinds and removes APIs without successor in "+mode+" mode", func() {
+			modifiedManifest, err := replace(mapFile, manifest, kubeVersion125)
+
+			// the text mode trims the trailing line feed when removing a resource
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(strings.TrimRight(modifiedManifest, "\n")).To(gomega.Equal(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: test
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: test`))
+		})
+
+		ginkgo.It("does not apply a wildcard rule to a kind with a deprecatedAPI string in "+mode+" mode", func() {
+			stringMapFile, err := LoadMapFile(`mappings:
+  - deprecatedAPI: "apiVersion: extensions/v1beta1\nkind: DaemonSet\n"
+    newAPI: "apiVersion: apps/v1\nkind: DaemonSet\n"
+    removedInVersion: "v1.20"
+  - group: extensions
+    version: v1beta1
+    kind: "*"
+    newGroup: apps
+    newVersion: v1
+    removedInVersion: "v1.16"
+`)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			modifiedManifest, err := replace(stringMapFile, manifest, "v1.16")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: apps/v1\nkind: Deployment\n"))
+			gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: extensions/v1beta1\nkind: DaemonSet\n"))
+		})
+	}
+})
+
+var _ = ginkgo.Describe("replacing deprecated APIs with chained mappings", func() {
+	// the chain is listed from its end so that the mappings must be ordered to be followed
+	var chainMapFile = `mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta2\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    deprecatedInVersion: "v1.29"
+    removedInVersion: "v1.32"
+  - group: example.com
+    version: v1beta1
+    kind: Widget
+    newVersion: v1beta2
+    deprecatedInVersion: "v1.26"
+    removedInVersion: "v1.26"
+`
+
+	var manifest = `---
+apiVersion: example.com/v1beta1
+kind: Widget
+metadata:
+  name: test
+`
+
+	for mode, replace := range ReplaceModes {
+		ginkgo.It("follows the chain to the newest API of the Kubernetes version in "+mode+" mode", func() {
+			mapFile, err := LoadMapFile(chainMapFile)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			modifiedManifest, err := replace(mapFile, manifest, "v1.32")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: example.com/v1\n"))
+
+			modifiedManifest, err = replace(mapFile, manifest, "v1.28")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: example.com/v1beta2\n"))
+		})
+	}
+
+	ginkgo.It("rejects mappings which form a cycle", func() {
+		_, err := LoadMapFile(chainMapFile + `  - deprecatedAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    deprecatedInVersion: "v1.33"
+`)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("mappings form a cycle")))
+	})
+
+	ginkgo.It("rejects conflicting mappings of the same API", func() {
+		_, err := LoadMapFile(chainMapFile + `  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    deprecatedInVersion: "v1.26"
+    removedInVersion: "v1.26"
+`)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("conflicting mappings 2 and 3")))
+	})
+})
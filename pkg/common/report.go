@@ -0,0 +1,166 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// MapAction is what is done to the resources of a deprecated or removed API
+type MapAction string
+
+const (
+	// ActionReplaced means the resources were mapped to the new API
+	ActionReplaced MapAction = "replaced"
+	// ActionRemoved means the resources were removed as the API has no successor
+	ActionRemoved MapAction = "removed"
+	// ActionKept means the resources were not mapped, for example as the API is deprecated but not
+	// removed and only removed APIs are mapped, the reason is set in the mapped API
+	ActionKept MapAction = "kept"
+)
+
+// APICategory tells whether a deprecated API is still served in the Kubernetes version
+type APICategory string
+
+const (
+	// CategoryDeprecated means the API is deprecated but still served in the Kubernetes version
+	CategoryDeprecated APICategory = "deprecated"
+	// CategoryRemoved means the API is removed in the Kubernetes version
+	CategoryRemoved APICategory = "removed"
+)
+
+// ReleaseStatus is the outcome of checking a release for deprecated or removed APIs
+type ReleaseStatus string
+
+const (
+	// StatusMapped means deprecated or removed APIs were found in the release
+	StatusMapped ReleaseStatus = "mapped"
+	// StatusClean means the release has no deprecated or removed APIs
+	StatusClean ReleaseStatus = "clean"
+	// StatusSkipped means the release was not checked
+	StatusSkipped ReleaseStatus = "skipped"
+	// StatusDeprecated means the release has APIs which are deprecated, but not removed, in the Kubernetes version
+	StatusDeprecated ReleaseStatus = "deprecated"
+	// StatusRemoved means the release has APIs which are removed in the Kubernetes version
+	StatusRemoved ReleaseStatus = "removed"
+	// StatusFailed means the release could not be checked or updated
+	StatusFailed ReleaseStatus = "failed"
+)
+
+// MappedAPI is a mapping which matched resources in a release manifest
+type MappedAPI struct {
+	// Hook is the name of the hook the resources are in, empty for the release manifest
+	Hook string `json:"hook,omitempty"`
+
+	DeprecatedAPI       string      `json:"deprecatedAPI"`
+	NewAPI              string      `json:"newAPI,omitempty"`
+	DeprecatedInVersion string      `json:"deprecatedInVersion,omitempty"`
+	RemovedInVersion    string      `json:"removedInVersion,omitempty"`
+	Count               int         `json:"count"`
+	Category            APICategory `json:"category"`
+	Action              MapAction   `json:"action"`
+
+	// Reason is why the resources were kept, only set for the kept action
+	Reason string `json:"reason,omitempty"`
+}
+
+// UnsupportedAPI is a resource in a release manifest whose API is not served by the cluster
+type UnsupportedAPI struct {
+	// Hook is the name of the hook the resource is in, empty for the release manifest
+	Hook string `json:"hook,omitempty"`
+
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+
+	// SuggestedAPIVersions are the API versions the cluster serves for the kind
+	SuggestedAPIVersions []string `json:"suggestedAPIVersions,omitempty"`
+}
+
+// ReleaseReport is the machine-readable result of checking and mapping a release. The functions which
+// check or map a release return a report of the deprecated or removed APIs found even when they also
+// return an error, so that the report of a release which failed can still be printed.
+type ReleaseReport struct {
+	Release   string        `json:"release"`
+	Namespace string        `json:"namespace"`
+	Revision  int           `json:"revision,omitempty"`
+	Status    ReleaseStatus `json:"status"`
+	Message   string        `json:"message,omitempty"`
+	DryRun    bool          `json:"dryRun,omitempty"`
+
+	// KubeVersion is the Kubernetes version the release was checked against
+	KubeVersion string `json:"kubeVersion,omitempty"`
+
+	Mappings []MappedAPI `json:"mappings"`
+
+	// Unsupported are the resources left with an API the cluster does not serve, only set when discovery is used
+	Unsupported []UnsupportedAPI `json:"unsupported,omitempty"`
+
+	// NewRevision is the revision added with the mapped APIs, unset if no revision was added
+	NewRevision int `json:"newRevision,omitempty"`
+
+	// Backup is where the original release version was backed up to before it was superseded
+	Backup string `json:"backup,omitempty"`
+
+	// Diff is the unified diff of the mapped resources, only set when requested
+	Diff string `json:"diff,omitempty"`
+}
+
+// HasRemovedAPIs returns true if the release has APIs which are removed in the Kubernetes version
+// the release was checked against, or which the cluster does not serve
+func (r *ReleaseReport) HasRemovedAPIs() bool {
+	if len(r.Unsupported) > 0 {
+		return true
+	}
+	for _, m := range r.Mappings {
+		if m.Category == CategoryRemoved {
+			return true
+		}
+	}
+	return false
+}
+
+// mappedAction returns what is done to the resources of the mapping when they are mapped
+func mappedAction(m *mapping.Mapping) MapAction {
+	if m.NewAPI == "" {
+		return ActionRemoved
+	}
+	return ActionReplaced
+}
+
+func newMappedAPI(m *mapping.Mapping, count int, kubeVersionStr string, action MapAction) MappedAPI {
+	category := CategoryDeprecated
+	if isRemoved(m, kubeVersionStr) {
+		category = CategoryRemoved
+	}
+	return MappedAPI{
+		DeprecatedAPI:       m.DeprecatedAPI,
+		NewAPI:              m.NewAPI,
+		DeprecatedInVersion: m.DeprecatedInVersion,
+		RemovedInVersion:    m.RemovedInVersion,
+		Count:               count,
+		Category:            category,
+		Action:              action,
+	}
+}
+
+// newKeptAPI returns the mapped API of a mapping whose resources are not mapped for the given reason
+func newKeptAPI(m *mapping.Mapping, count int, kubeVersionStr string, reason string) MappedAPI {
+	mappedAPI := newMappedAPI(m, count, kubeVersionStr, ActionKept)
+	mappedAPI.Reason = reason
+	return mappedAPI
+}
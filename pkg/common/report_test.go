@@ -0,0 +1,118 @@
+package common_test
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("classifying the APIs found in a release", func() {
+	var deprecated = common.MappedAPI{
+		DeprecatedAPI:       "apiVersion: flowcontrol.apiserver.k8s.io/v1beta3\nkind: FlowSchema\n",
+		DeprecatedInVersion: "v1.29",
+		RemovedInVersion:    "v1.32",
+		Category:            common.CategoryDeprecated,
+	}
+	var removed = common.MappedAPI{
+		DeprecatedAPI:    "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\n",
+		RemovedInVersion: "v1.25",
+		Category:         common.CategoryRemoved,
+	}
+
+	ginkgo.It("has removed APIs when an API is in the removed category", func() {
+		report := common.ReleaseReport{KubeVersion: "v1.30.2", Mappings: []common.MappedAPI{deprecated}}
+		gomega.Expect(report.HasRemovedAPIs()).To(gomega.BeFalse())
+
+		report.Mappings = append(report.Mappings, removed)
+		gomega.Expect(report.HasRemovedAPIs()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("has removed APIs when the cluster does not serve an API", func() {
+		report := common.ReleaseReport{
+			KubeVersion: "v1.30.2",
+			Unsupported: []common.UnsupportedAPI{{APIVersion: "example.com/v1alpha1", Kind: "Widget"}},
+		}
+		gomega.Expect(report.HasRemovedAPIs()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("maps only the removed APIs with the removed policy", func() {
+		manifest := `---
+apiVersion: flowcontrol.apiserver.k8s.io/v1beta3
+kind: FlowSchema
+metadata:
+  name: deprecated
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: removed
+`
+		mapper, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "v1.30.2",
+			MapOn:       common.MapOnRemoved,
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		modifiedManifest, mappedAPIs, err := mapper.Map(manifest)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: flowcontrol.apiserver.k8s.io/v1beta3\n"))
+		gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: policy/v1\n"))
+		gomega.Expect(mappedAPIs).To(gomega.HaveLen(2))
+		for _, mappedAPI := range mappedAPIs {
+			if mappedAPI.Category == common.CategoryRemoved {
+				gomega.Expect(mappedAPI.Action).To(gomega.Equal(common.ActionReplaced))
+			} else {
+				gomega.Expect(mappedAPI.Action).To(gomega.Equal(common.ActionKept))
+			}
+		}
+	})
+})
+
+var _ = ginkgo.Describe("mapping against a target Kubernetes version", func() {
+	var manifest = `---
+apiVersion: flowcontrol.apiserver.k8s.io/v1beta3
+kind: FlowSchema
+metadata:
+  name: test
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: test
+`
+
+	ginkgo.It("keeps the APIs whose new API the server does not serve yet", func() {
+		mapper, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion: "v1.32.0",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		mapper.SetServedAPIs(common.NewServedAPIs([]*metav1.APIResourceList{
+			{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets", Kind: "PodDisruptionBudget"}}},
+			{GroupVersion: "flowcontrol.apiserver.k8s.io/v1beta3", APIResources: []metav1.APIResource{{Name: "flowschemas", Kind: "FlowSchema"}}},
+		}))
+
+		modifiedManifest, mappedAPIs, err := mapper.Map(manifest)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: flowcontrol.apiserver.k8s.io/v1beta3\n"))
+		gomega.Expect(modifiedManifest).To(gomega.ContainSubstring("apiVersion: policy/v1\n"))
+
+		gomega.Expect(mappedAPIs).To(gomega.HaveLen(2))
+		for _, mappedAPI := range mappedAPIs {
+			if mappedAPI.Action == common.ActionKept {
+				gomega.Expect(mappedAPI.DeprecatedAPI).To(gomega.ContainSubstring("FlowSchema"))
+				gomega.Expect(mappedAPI.Reason).To(gomega.Equal("the supported API \"flowcontrol.apiserver.k8s.io/v1\" is not served by the Kubernetes server yet"))
+			}
+		}
+	})
+
+	ginkgo.It("does not accept both a Kubernetes version and a target version", func() {
+		_, err := common.NewManifestMapper(common.MapOptions{
+			KubeVersion:   "v1.29.0",
+			TargetVersion: "v1.32.0",
+		})
+		gomega.Expect(err).To(gomega.MatchError("A Kubernetes version and a target version may not be set together"))
+	})
+})
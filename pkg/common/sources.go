@@ -0,0 +1,162 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/discovery"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// VersionSource returns the Kubernetes version to map against
+type VersionSource interface {
+	// KubeVersion returns the Kubernetes version in semver format with a "v" prefix, such as "v1.29.3"
+	KubeVersion() (string, error)
+}
+
+// MappingSource returns the mapping data to map with
+type MappingSource interface {
+	// Mappings returns the validated mapping data
+	Mappings() (*mapping.Metadata, error)
+}
+
+// StaticVersion is a Kubernetes version set by the caller, such as "v1.29.3" or "1.29.3"
+type StaticVersion string
+
+// KubeVersion returns the version with a "v" prefix, or an error if it is not a valid version
+func (v StaticVersion) KubeVersion() (string, error) {
+	return parseKubeVersion(string(v))
+}
+
+// ServerVersion is the version of the Kubernetes server of the client
+type ServerVersion struct {
+	Client discovery.ServerVersionInterface
+}
+
+// KubeVersion gets the version from the Kubernetes server
+func (v ServerVersion) KubeVersion() (string, error) {
+	info, err := v.Client.ServerVersion()
+	if err != nil {
+		return "", serverError(err)
+	}
+	kubeVersionStr, err := parseKubeVersion(info.GitVersion)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to get Kubernetes server version")
+	}
+	return kubeVersionStr, nil
+}
+
+// NewVersionSource returns the source of the Kubernetes version to map against of the map options: the
+// Kubernetes version if it is set, or else the target version, or else the version of the Kubernetes server.
+// For a target version, the APIs the server serves are also returned, so that deprecated APIs are only
+// mapped to new APIs which the server already serves. The discovery client is not used, and may be nil,
+// if a Kubernetes version is set.
+func NewVersionSource(mapOptions MapOptions, client discovery.DiscoveryInterface) (VersionSource, *ServedAPIs, error) {
+	switch {
+	case mapOptions.KubeVersion != "" && mapOptions.TargetVersion != "":
+		return nil, nil, errors.New("A Kubernetes version and a target version may not be set together")
+	case mapOptions.KubeVersion != "":
+		kubeVersionStr, err := parseKubeVersion(mapOptions.KubeVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Using Kubernetes version \"%s\" to map against.\n", kubeVersionStr)
+		return StaticVersion(kubeVersionStr), nil, nil
+	case mapOptions.TargetVersion != "":
+		kubeVersionStr, err := parseKubeVersion(mapOptions.TargetVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		serverVersionStr, err := ServerVersion{Client: client}.KubeVersion()
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Using target Kubernetes version \"%s\" to map against, the Kubernetes server version is \"%s\".\n", kubeVersionStr, serverVersionStr)
+		served, err := DiscoverServedAPIs(client)
+		if err != nil {
+			return nil, nil, serverError(err)
+		}
+		return StaticVersion(kubeVersionStr), served, nil
+	default:
+		return ServerVersion{Client: client}, nil, nil
+	}
+}
+
+// MapFiles are mapping files which are layered over the default mapping file, unless NoDefault is set
+type MapFiles struct {
+	Files     []string
+	NoDefault bool
+}
+
+// Mappings loads and layers the mapping files
+func (f MapFiles) Mappings() (*mapping.Metadata, error) {
+	mapMetadata, err := mapping.LoadMapfiles(!f.NoDefault, f.Files...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load mapping file")
+	}
+	return mapMetadata, nil
+}
+
+// StaticMappings is mapping data held by the caller
+type StaticMappings struct {
+	Metadata *mapping.Metadata
+}
+
+// Mappings validates and returns the mapping data
+func (s StaticMappings) Mappings() (*mapping.Metadata, error) {
+	if s.Metadata == nil {
+		return nil, errors.New("No mapping data")
+	}
+	if err := s.Metadata.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid mapping data")
+	}
+	return s.Metadata, nil
+}
+
+// LoadMappings loads the mapping files of the map options and layers the additional mappings over them
+func LoadMappings(mapOptions MapOptions, additionalMappings ...*mapping.Mapping) (*mapping.Metadata, error) {
+	mapFiles := MapFiles{Files: mapOptions.MapFiles, NoDefault: mapOptions.NoDefaultMapFile}
+	if mapOptions.MapFile != "" {
+		mapFiles = MapFiles{Files: append([]string{mapOptions.MapFile}, mapOptions.MapFiles...), NoDefault: true}
+	}
+	mapMetadata, err := mapFiles.Mappings()
+	if err != nil {
+		return nil, err
+	}
+	if len(additionalMappings) > 0 {
+		mapMetadata.Merge(&mapping.Metadata{Mappings: additionalMappings})
+		if err = mapMetadata.Validate(); err != nil {
+			return nil, errors.Wrap(err, "Invalid additional mappings")
+		}
+	}
+	return mapMetadata, nil
+}
+
+// CheckMapCoverage logs a warning, or returns an error if strict is set, when the mapping data
+// is not known to cover the Kubernetes version
+func CheckMapCoverage(mapMetadata *mapping.Metadata, kubeVersionStr string, strict bool) error {
+	if err := mapMetadata.CheckCoverage(kubeVersionStr); err != nil {
+		if strict {
+			return err
+		}
+		log.Printf("WARNING: %s, the APIs deprecated or removed in later versions may be missed.\n", err)
+	}
+	return nil
+}
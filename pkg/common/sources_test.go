@@ -0,0 +1,44 @@
+package common_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("selecting the Kubernetes version source", func() {
+	ginkgo.It("uses the Kubernetes version without contacting the server", func() {
+		versions, served, err := common.NewVersionSource(common.MapOptions{KubeVersion: "1.29.0"}, nil)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(served).To(gomega.BeNil())
+		gomega.Expect(versions.KubeVersion()).To(gomega.Equal("v1.29.0"))
+	})
+
+	ginkgo.It("rejects a Kubernetes version and a target version together", func() {
+		_, _, err := common.NewVersionSource(common.MapOptions{KubeVersion: "v1.29.0", TargetVersion: "v1.32.0"}, nil)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("may not be set together")))
+	})
+})
+
+var _ = ginkgo.Describe("loading the mappings of the map options", func() {
+	ginkgo.It("uses the deprecated map file instead of the default mappings", func() {
+		mapFile := filepath.Join(ginkgo.GinkgoT().TempDir(), "Map.yaml")
+		gomega.Expect(os.WriteFile(mapFile, []byte(`mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.20"
+`), 0o600)).To(gomega.Succeed())
+
+		mapMetadata, err := common.LoadMappings(common.MapOptions{MapFile: mapFile})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(mapMetadata.Mappings).To(gomega.HaveLen(1))
+
+		mapMetadata, err = common.LoadMappings(common.MapOptions{MapFiles: []string{mapFile}})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(len(mapMetadata.Mappings)).To(gomega.BeNumerically(">", 1))
+	})
+})
@@ -0,0 +1,379 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// wildcardSegment matches every item of a list or every value of a map in a transformation path
+const wildcardSegment = "*"
+
+// field is a node found at a transformation path
+type field struct {
+	// parent is the map or list which holds the node
+	parent *yaml.Node
+	key    string
+	node   *yaml.Node
+
+	// wildcards are the keys or indexes matched by the wildcard segments of the path
+	wildcards []string
+}
+
+// replaceManifestAPI replaces the deprecated API with the supported API in the manifest, and applies the
+// transformations to the resources of the deprecated API. Both are done in one pass over the documents, so
+// only a resource whose text contains the deprecated API string is transformed: a resource with the kind
+// before the apiVersion, for example, is neither mapped nor transformed.
+func replaceManifestAPI(manifest string, deprecatedAPI string, supportedAPI string, transforms []mapping.Transform) (string, error) {
+	header, err := parseAPIHeader(deprecatedAPI)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse the deprecated API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+	}
+
+	var sb strings.Builder
+	for _, document := range splitManifestDocuments(manifest) {
+		if strings.Contains(document.raw, deprecatedAPI) {
+			matches := document.matches(header)
+			document.raw = strings.ReplaceAll(document.raw, deprecatedAPI, supportedAPI)
+			if matches {
+				if err := document.transform(transforms); err != nil {
+					return "", errors.Wrapf(err, "Failed to transform the fields of API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+				}
+			}
+		}
+		sb.WriteString(document.raw)
+	}
+	return sb.String(), nil
+}
+
+// transform applies the transformations to the document. The document is only re-encoded
+// if a transformation changed it, which keeps the key order and comments but may change
+// the indentation and quoting of the whole document. ManifestDiff notes such documents.
+func (d *manifestDocument) transform(transforms []mapping.Transform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	separator, body := d.splitSeparator()
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		return err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return errors.New("the resource is not a YAML map")
+	}
+
+	changed := false
+	for _, transform := range transforms {
+		applied, err := applyTransform(root.Content[0], transform)
+		if err != nil {
+			return errors.Wrapf(err, "%s %s", transform.Op, transform.Path)
+		}
+		changed = changed || applied
+	}
+	if !changed {
+		return nil
+	}
+
+	encoded, err := encodeDocument(&root, body)
+	if err != nil {
+		return err
+	}
+	d.raw = separator + encoded
+	d.decodeHeader()
+	return nil
+}
+
+// splitSeparator returns the document separator line of the document, which is lost when encoding, and its body
+func (d *manifestDocument) splitSeparator() (string, string) {
+	if line, rest, found := strings.Cut(d.raw, "\n"); found && isDocumentSeparator(line) {
+		return line + "\n", rest
+	}
+	return "", d.raw
+}
+
+// encodeDocument encodes the root node of a document body, ending it with a line feed only if the body does
+func encodeDocument(root *yaml.Node, body string) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	encoded := buf.String()
+	if !strings.HasSuffix(body, "\n") {
+		encoded = strings.TrimSuffix(encoded, "\n")
+	}
+	return encoded, nil
+}
+
+// isEncoded returns true if the body of the document is the same when it is decoded and encoded again,
+// which is the case for a document re-encoded by a transformation
+func (d *manifestDocument) isEncoded() bool {
+	_, body := d.splitSeparator()
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		return false
+	}
+	encoded, err := encodeDocument(&root, body)
+	return err == nil && encoded == body
+}
+
+// applyTransform applies a transformation to a resource and returns true if the resource was changed
+func applyTransform(resource *yaml.Node, transform mapping.Transform) (bool, error) {
+	path, err := splitPath(transform.Path)
+	if err != nil {
+		return false, err
+	}
+	if transform.IfAbsent != "" {
+		ifAbsent, err := splitPath(transform.IfAbsent)
+		if err != nil {
+			return false, err
+		}
+		if len(findFields(resource, ifAbsent, nil)) > 0 {
+			return false, nil
+		}
+	}
+
+	changed := false
+	switch transform.Op {
+	case mapping.TransformMove, mapping.TransformCopy:
+		from, err := splitPath(transform.From)
+		if err != nil {
+			return false, err
+		}
+		for _, source := range findFields(resource, from, nil) {
+			if !hasType(source.node, transform.Type) {
+				continue
+			}
+			target, err := substituteWildcards(path, source.wildcards)
+			if err != nil {
+				return false, err
+			}
+			if !setField(resource, target, copyNode(source.node)) {
+				continue
+			}
+			if transform.Op == mapping.TransformMove {
+				removeField(source)
+			}
+			changed = true
+		}
+	case mapping.TransformAdd:
+		var value yaml.Node
+		if err := value.Encode(transform.Value); err != nil {
+			return false, err
+		}
+		// the wildcards are expanded on the parent, the field itself is created
+		if path[len(path)-1] == wildcardSegment {
+			return false, errors.New("the last segment of the path cannot be a wildcard")
+		}
+		parents := []field{{node: resource}}
+		if len(path) > 1 {
+			parents = findFields(resource, path[:len(path)-1], nil)
+		}
+		for _, parent := range parents {
+			target, err := substituteWildcards(path, parent.wildcards)
+			if err != nil {
+				return false, err
+			}
+			if setField(resource, target, copyNode(&value)) {
+				changed = true
+			}
+		}
+	case mapping.TransformRemove:
+		for _, target := range findFields(resource, path, nil) {
+			if hasType(target.node, transform.Type) {
+				removeField(target)
+				changed = true
+			}
+		}
+	default:
+		return false, errors.Errorf("unknown operation \"%s\"", transform.Op)
+	}
+	return changed, nil
+}
+
+// splitPath splits a JSON pointer into its unescaped segments
+func splitPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") || len(path) == 1 {
+		return nil, errors.Errorf("invalid path \"%s\", expected a JSON pointer such as /spec/selector", path)
+	}
+	segments := strings.Split(path[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+	}
+	return segments, nil
+}
+
+// substituteWildcards sets the wildcard segments of the path to the matched keys or indexes
+func substituteWildcards(path []string, wildcards []string) ([]string, error) {
+	substituted := make([]string, len(path))
+	w := 0
+	for i, segment := range path {
+		if segment == wildcardSegment {
+			if w == len(wildcards) {
+				return nil, errors.New("the path has more wildcards than the source path")
+			}
+			segment = wildcards[w]
+			w++
+		}
+		substituted[i] = segment
+	}
+	return substituted, nil
+}
+
+// findFields returns the fields at the path, expanding the wildcard segments
+func findFields(node *yaml.Node, path []string, wildcards []string) []field {
+	if len(path) == 0 {
+		return nil
+	}
+	segment, rest := path[0], path[1:]
+
+	var found []field
+	visit := func(key string, child *yaml.Node) {
+		matched := wildcards
+		if segment == wildcardSegment {
+			matched = append(append([]string{}, wildcards...), key)
+		}
+		if len(rest) == 0 {
+			found = append(found, field{parent: node, key: key, node: child, wildcards: matched})
+			return
+		}
+		found = append(found, findFields(child, rest, matched)...)
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; segment == wildcardSegment || segment == key {
+				visit(key, node.Content[i+1])
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if key := strconv.Itoa(i); segment == wildcardSegment || segment == key {
+				visit(key, item)
+			}
+		}
+	}
+	return found
+}
+
+// setField sets the field at the path to the value, creating the maps on the way. The field is not set,
+// and false is returned, if it already exists or if the path goes through a value which is not a map or list.
+func setField(node *yaml.Node, path []string, value *yaml.Node) bool {
+	for i, segment := range path {
+		last := i == len(path)-1
+		switch node.Kind {
+		case yaml.MappingNode:
+			var child *yaml.Node
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == segment {
+					child = node.Content[j+1]
+					break
+				}
+			}
+			if child != nil {
+				if last {
+					return false
+				}
+				node = child
+				continue
+			}
+			if !last {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			} else {
+				child = value
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment}, child)
+			node = child
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node.Content) || last {
+				return false
+			}
+			node = node.Content[index]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// removeField removes the field from its parent map or list
+func removeField(f field) {
+	switch f.parent.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(f.parent.Content); i += 2 {
+			if f.parent.Content[i+1] == f.node {
+				f.parent.Content = append(f.parent.Content[:i], f.parent.Content[i+2:]...)
+				return
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range f.parent.Content {
+			if item == f.node {
+				f.parent.Content = append(f.parent.Content[:i], f.parent.Content[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// hasType returns true if the node is of the given transformation type, or if no type is given
+func hasType(node *yaml.Node, fieldType string) bool {
+	switch fieldType {
+	case "":
+		return true
+	case "object":
+		return node.Kind == yaml.MappingNode
+	case "array":
+		return node.Kind == yaml.SequenceNode
+	}
+	if node.Kind != yaml.ScalarNode {
+		return false
+	}
+	switch node.ShortTag() {
+	case "!!str":
+		return fieldType == "string"
+	case "!!int", "!!float":
+		return fieldType == "number"
+	case "!!bool":
+		return fieldType == "boolean"
+	}
+	return false
+}
+
+// copyNode returns a deep copy of the node
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+	return &copied
+}
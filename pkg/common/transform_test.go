@@ -0,0 +1,187 @@
+package common_test
+
+import (
+	"strings"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("transforming the fields of mapped resources", ginkgo.Ordered, func() {
+	var mapFile *mapping.Metadata
+	var kubeVersion122 = "v1.22"
+
+	var manifest = `---
+# Source: test/templates/ingress.yaml
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: test
+spec:
+  backend:
+    serviceName: default
+    servicePort: 80
+  rules:
+    - host: example.com
+      http:
+        paths:
+          - path: /
+            backend:
+              serviceName: web
+              servicePort: http
+          - path: /api
+            pathType: Prefix
+            backend:
+              serviceName: api
+              servicePort: 8080
+---
+# Source: test/templates/deployment.yaml
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  template:
+    metadata:
+      labels:
+        app: test
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unchanged
+spec:
+    selector: {}
+`
+
+	var expected = `---
+# Source: test/templates/ingress.yaml
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: test
+spec:
+  rules:
+    - host: example.com
+      http:
+        paths:
+          - path: /
+            backend:
+              service:
+                name: web
+                port:
+                  name: http
+            pathType: ImplementationSpecific
+          - path: /api
+            pathType: Prefix
+            backend:
+              service:
+                name: api
+                port:
+                  number: 8080
+  defaultBackend:
+    service:
+      name: default
+      port:
+        number: 80
+---
+# Source: test/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  template:
+    metadata:
+      labels:
+        app: test
+  selector:
+    matchLabels:
+      app: test
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unchanged
+spec:
+    selector: {}
+`
+
+	ginkgo.BeforeAll(func() {
+		var err error
+		mapFile, err = mapping.LoadMapfile("../../config/Map.yaml")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	for mode, replace := range ReplaceModes {
+		ginkgo.It("rewrites the fields which changed in the new API in "+mode+" mode", func() {
+			modifiedManifest, err := replace(mapFile, manifest, kubeVersion122)
+
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(modifiedManifest).To(gomega.Equal(expected))
+		})
+	}
+
+	for mode, replace := range ReplaceModes {
+		ginkgo.It("keeps a selector with match expressions only in "+mode+" mode", func() {
+			var selectorManifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  selector:
+    matchExpressions:
+      - key: app
+        operator: In
+        values: [test]
+  template:
+    metadata:
+      labels:
+        app: test
+        tier: web
+`
+			modifiedManifest, err := replace(mapFile, selectorManifest, kubeVersion122)
+
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(modifiedManifest).To(gomega.Equal(strings.Replace(selectorManifest, "extensions/v1beta1", "apps/v1", 1)))
+		})
+	}
+
+	ginkgo.It("only transforms the resources whose API is mapped in text mode", func() {
+		var kindFirstManifest = `---
+kind: Ingress
+apiVersion: networking.k8s.io/v1beta1
+metadata:
+  name: test
+spec:
+  backend:
+    serviceName: default
+    servicePort: 80
+`
+		modifiedManifest, err := common.ReplaceManifestData(mapFile, kindFirstManifest, kubeVersion122)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(kindFirstManifest))
+
+		modifiedManifest, err = common.ReplaceManifestData(mapFile, kindFirstManifest+manifest, kubeVersion122)
+
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(modifiedManifest).To(gomega.Equal(kindFirstManifest + expected))
+	})
+
+	ginkgo.It("fails on an invalid transformation", func() {
+		metadata := &mapping.Metadata{Mappings: []*mapping.Mapping{{
+			DeprecatedAPI:    "apiVersion: apps/v1beta1\nkind: Deployment\n",
+			NewAPI:           "apiVersion: apps/v1\nkind: Deployment\n",
+			RemovedInVersion: "v1.16",
+			Transforms:       []mapping.Transform{{Op: "rename", Path: "/spec"}},
+		}}}
+
+		_, err := common.ReplaceManifestData(metadata, "apiVersion: apps/v1beta1\nkind: Deployment\nspec: {}\n", kubeVersion122)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unknown operation \"rename\"")))
+	})
+})
@@ -16,64 +16,76 @@ limitations under the License.
 
 package common
 
-/*
-This functionality is copied from
-https://github.com/maorfr/helm-plugin-utils/blob/master/pkg/utils.go.
-The reason for duplicating it is because it is no longer possible to keep maintaining the
-source repo in sync with Kubernetes Go client changes as required by helm-mapkubeapis.
-It has been copied in co-operation with author maorfr.
-*/
-
 import (
-	"log"
-	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
+	"io/fs"
 
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/cli"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// GetClientSetWithKubeConfig returns a kubernetes ClientSet
-func GetClientSetWithKubeConfig(kubeConfigFile, context string) *kubernetes.Clientset {
-	var kubeConfigFiles []string
-	if kubeConfigFile != "" {
-		kubeConfigFiles = append(kubeConfigFiles, kubeConfigFile)
-	} else if kubeConfigPath := os.Getenv("KUBECONFIG"); kubeConfigPath != "" {
-		// The KUBECONFIG environment variable holds a list of kubeconfig files.
-		// For Linux and Mac, the list is colon-delimited. For Windows, the list
-		// is semicolon-delimited. Ref:
-		// https://kubernetes.io/docs/concepts/configuration/organize-cluster-access-kubeconfig/#the-kubeconfig-environment-variable
-		var separator string
-		if runtime.GOOS == "windows" {
-			separator = ";"
-		} else {
-			separator = ":"
-		}
-		kubeConfigFiles = strings.Split(kubeConfigPath, separator)
-	} else {
-		kubeConfigFiles = append(kubeConfigFiles, filepath.Join(os.Getenv("HOME"), ".kube", "config"))
+// NewEnvSettings returns the Helm settings of the environment, such as HELM_KUBEAPISERVER, HELM_KUBETOKEN,
+// HELM_KUBECAFILE, HELM_KUBEINSECURE_SKIP_TLS_VERIFY, HELM_KUBEASUSER, HELM_KUBEASGROUPS and HELM_BURST_LIMIT.
+// The kubeconfig file and context are set to the ones of the Kubernetes configuration, if they are set.
+// The REST client getter of the settings should be shared by all the clients of a Kubernetes cluster, so that
+// the Helm release storage and the Kubernetes version lookup connect to the same cluster in the same way.
+func NewEnvSettings(kubeConfig KubeConfig) *cli.EnvSettings {
+	settings := cli.New()
+	if kubeConfig.File != "" {
+		settings.KubeConfig = kubeConfig.File
 	}
+	if kubeConfig.Context != "" {
+		settings.KubeContext = kubeConfig.Context
+	}
+	return settings
+}
+
+// GetClientSetWithKubeConfig returns a kubernetes ClientSet of the Helm environment with the kubeconfig
+// file and context, see NewEnvSettings and NewClientSet
+func GetClientSetWithKubeConfig(kubeConfigFile, context string) (*kubernetes.Clientset, error) {
+	return NewClientSet(NewEnvSettings(KubeConfig{File: kubeConfigFile, Context: context}).RESTClientGetter())
+}
+
+// RESTConfigGetter returns the REST configuration of a Kubernetes cluster. The REST client getters of
+// the Helm settings and of a Helm action configuration are RESTConfigGetters.
+type RESTConfigGetter interface {
+	ToRESTConfig() (*rest.Config, error)
+}
 
-	config, err := buildConfigFromFlags(context, kubeConfigFiles)
+// NewClientSet returns a kubernetes ClientSet of the REST client getter. A *KubeConfigNotFoundError is returned
+// if none of the kubeconfig files exist, and a *ContextNotFoundError if the context is not in the kubeconfig.
+func NewClientSet(getter RESTConfigGetter) (*kubernetes.Clientset, error) {
+	config, err := getter.ToRESTConfig()
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, restConfigError(getter, err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, errors.Wrap(err, "Failed to create the Kubernetes client")
 	}
 
-	return clientset
+	return clientset, nil
 }
 
-func buildConfigFromFlags(context string, kubeConfigFiles []string) (*rest.Config, error) {
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{Precedence: kubeConfigFiles},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: context,
-		}).ClientConfig()
+// restConfigError returns the error of loading the kubeconfig of the REST client getter as a
+// *KubeConfigNotFoundError or a *ContextNotFoundError if it is one
+func restConfigError(getter RESTConfigGetter, err error) error {
+	clientGetter, ok := getter.(genericclioptions.RESTClientGetter)
+	if !ok {
+		return errors.Wrap(err, "Failed to load the kubeconfig")
+	}
+	loader := clientGetter.ToRawKubeConfigLoader()
+	if clientcmd.IsEmptyConfig(err) || errors.Is(err, fs.ErrNotExist) {
+		return &KubeConfigNotFoundError{Files: loader.ConfigAccess().GetLoadingPrecedence()}
+	}
+	if flags, ok := getter.(*genericclioptions.ConfigFlags); ok && flags.Context != nil && *flags.Context != "" {
+		if rawConfig, rawErr := loader.RawConfig(); rawErr == nil && rawConfig.Contexts[*flags.Context] == nil {
+			return &ContextNotFoundError{Context: *flags.Context}
+		}
+	}
+	return errors.Wrap(err, "Failed to load the kubeconfig")
 }
@@ -0,0 +1,139 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	openapi_v2 "github.com/google/gnostic-models/openapiv2"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/discovery"
+	"k8s.io/kubectl/pkg/util/openapi"
+	"k8s.io/kubectl/pkg/validation"
+)
+
+// ManifestValidator checks that mapped manifests can still be decoded and, if it has an
+// OpenAPI schema, that the mapped resources are valid for their new APIs
+type ManifestValidator struct {
+	schema validation.Schema
+}
+
+// NewManifestValidator returns a validator which validates the resources against the OpenAPI
+// schema of the given resources. If resources is nil, the manifests are only decoded.
+func NewManifestValidator(resources openapi.Resources) *ManifestValidator {
+	validator := &ManifestValidator{}
+	if resources != nil {
+		validator.schema = validation.NewSchemaValidation(resourcesGetter{resources})
+	}
+	return validator
+}
+
+// resourcesGetter returns OpenAPI resources which are already loaded
+type resourcesGetter struct {
+	resources openapi.Resources
+}
+
+func (g resourcesGetter) OpenAPISchema() (openapi.Resources, error) {
+	return g.resources, nil
+}
+
+// LoadDiscoverySchema loads the OpenAPI schema served by the cluster
+func LoadDiscoverySchema(client discovery.OpenAPISchemaInterface) (openapi.Resources, error) {
+	resources, err := openapi.NewOpenAPIParser(client).Parse()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the OpenAPI schema from the Kubernetes server")
+	}
+	return resources, nil
+}
+
+// LoadSchemaDir loads the OpenAPI v2 schema of a Kubernetes version from a directory. The schema
+// is read from "<version>.json", such as "v1.29.3.json", or else from "<major>.<minor>.json",
+// such as "v1.29.json". The file is the "api/openapi-spec/swagger.json" of the Kubernetes release.
+func LoadSchemaDir(dir string, kubeVersionStr string) (openapi.Resources, error) {
+	candidates := []string{kubeVersionStr + ".json"}
+	if majorMinor := semver.MajorMinor(kubeVersionStr); majorMinor != "" && majorMinor != kubeVersionStr {
+		candidates = append(candidates, majorMinor+".json")
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(dir, candidate)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read the OpenAPI schema %s", path)
+		}
+		document, err := openapi_v2.ParseDocument(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse the OpenAPI schema %s", path)
+		}
+		resources, err := openapi.NewOpenAPIData(document)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load the OpenAPI schema %s", path)
+		}
+		return resources, nil
+	}
+	return nil, errors.Errorf("No OpenAPI schema for Kubernetes version \"%s\" in %s, expected one of: %s",
+		kubeVersionStr, dir, strings.Join(candidates, ", "))
+}
+
+// Validate checks every document of the modified manifest. Documents which are the same as in
+// the original manifest are only decoded, as they were not changed by the mapping.
+func (v *ManifestValidator) Validate(origManifest, modifiedManifest string) error {
+	unchanged := make(map[string]bool)
+	for _, document := range splitManifestDocuments(origManifest) {
+		unchanged[document.raw] = true
+	}
+
+	var problems []string
+	for i, document := range splitManifestDocuments(modifiedManifest) {
+		if err := v.validateDocument(document, unchanged[document.raw]); err != nil {
+			problems = append(problems, fmt.Sprintf("document %d (%s): %s", i+1, documentID(document), err))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("Invalid mapped manifest:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func (v *ManifestValidator) validateDocument(document *manifestDocument, unchanged bool) error {
+	var content interface{}
+	if err := yaml.Unmarshal([]byte(document.raw), &content); err != nil {
+		return err
+	}
+	if content == nil {
+		// an empty document, such as a template which rendered nothing
+		return nil
+	}
+	if _, ok := content.(map[string]interface{}); !ok {
+		return errors.New("the document is not a YAML map")
+	}
+	if document.apiVersion == nil || document.apiVersion.Value == "" || document.kind == nil || document.kind.Value == "" {
+		return errors.New("apiVersion and kind are required")
+	}
+	if v.schema == nil || unchanged {
+		return nil
+	}
+	return v.schema.ValidateBytes([]byte(document.raw))
+}
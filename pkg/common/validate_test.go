@@ -0,0 +1,104 @@
+package common_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+// schema is a minimal OpenAPI v2 schema with the apps/v1 Deployment API
+const schema = `{
+  "swagger": "2.0",
+  "info": {"title": "Kubernetes", "version": "v1.29.0"},
+  "paths": {},
+  "definitions": {
+    "io.k8s.api.apps.v1.Deployment": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"type": "object"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "type": "object",
+      "required": ["selector"],
+      "properties": {
+        "replicas": {"type": "integer", "format": "int32"},
+        "selector": {"type": "object"}
+      }
+    }
+  }
+}`
+
+var _ = ginkgo.Describe("validating mapped manifests", func() {
+	var deploymentMapping = func(transforms ...mapping.Transform) *mapping.Metadata {
+		return &mapping.Metadata{Mappings: []*mapping.Mapping{{
+			DeprecatedAPI:    "apiVersion: apps/v1beta1\nkind: Deployment\n",
+			NewAPI:           "apiVersion: apps/v1\nkind: Deployment\n",
+			RemovedInVersion: "v1.16",
+			Transforms:       transforms,
+		}}}
+	}
+
+	var origManifest = `---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  replicas: 1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployed
+spec:
+  replicas: 1
+`
+
+	ginkgo.It("only decodes the documents without a schema", func() {
+		validator := common.NewManifestValidator(nil)
+
+		gomega.Expect(validator.Validate(origManifest, origManifest)).To(gomega.Succeed())
+		gomega.Expect(validator.Validate(origManifest, "---\n# Source: empty.yaml\n---\nkind: Deployment\n")).To(
+			gomega.MatchError(gomega.ContainSubstring("document 2 (Deployment/): apiVersion and kind are required")))
+		gomega.Expect(validator.Validate(origManifest, "apiVersion: [v1\n")).To(
+			gomega.MatchError(gomega.ContainSubstring("document 1 (unknown)")))
+	})
+
+	ginkgo.It("validates the mapped documents against the schema for the Kubernetes version", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		gomega.Expect(os.WriteFile(filepath.Join(dir, "v1.29.json"), []byte(schema), 0o600)).To(gomega.Succeed())
+
+		resources, err := common.LoadSchemaDir(dir, "v1.29.3")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		validator := common.NewManifestValidator(resources)
+
+		// the selector is missing in the mapped Deployment, the unchanged one is not validated
+		modifiedManifest, err := common.ReplaceManifestDocuments(deploymentMapping(), origManifest, "v1.29.3")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		err = validator.Validate(origManifest, modifiedManifest)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("document 1 (Deployment/test)")))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("missing required field \"selector\"")))
+		gomega.Expect(err).ToNot(gomega.MatchError(gomega.ContainSubstring("Deployment/deployed")))
+
+		modifiedManifest, err = common.ReplaceManifestDocuments(deploymentMapping(mapping.Transform{
+			Op: mapping.TransformAdd, Path: "/spec/selector", Value: map[string]interface{}{},
+		}), origManifest, "v1.29.3")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(validator.Validate(origManifest, modifiedManifest)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("fails when there is no schema for the Kubernetes version", func() {
+		_, err := common.LoadSchemaDir(ginkgo.GinkgoT().TempDir(), "v1.30.0")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("expected one of: v1.30.0.json, v1.30.json")))
+	})
+})
@@ -0,0 +1,278 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Problem is a problem found in a map file
+type Problem struct {
+	// Line is the line of the map file the problem is found at, or 0 if it is not known
+	Line int
+
+	// Message describes the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// LintMapfile reads a map file and returns the problems found in it
+func LintMapfile(filename string) ([]Problem, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(b), nil
+}
+
+// Lint returns the problems found in the content of a map file. Unlike LoadMapfile, which stops at the
+// first error, every mapping is checked so that all problems are reported with their line numbers.
+func Lint(data []byte) []Problem {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+	if len(root.Content) == 0 {
+		return []Problem{{Message: "the map file is empty"}}
+	}
+
+	document := root.Content[0]
+	if document.Kind != yamlv3.MappingNode {
+		return []Problem{{Line: document.Line, Message: "the map file is not a YAML map"}}
+	}
+	var problems []Problem
+	var mappingsLine int
+	var entries []*yamlv3.Node
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		key, value := document.Content[i], document.Content[i+1]
+		switch key.Value {
+		case "schemaVersion", "dataVersion", "date", "coversKubeVersion":
+			header := &Metadata{}
+			raw, err := yamlv3.Marshal(map[string]*yamlv3.Node{key.Value: value})
+			if err == nil {
+				err = yaml.UnmarshalStrict(raw, header)
+			}
+			if err == nil {
+				err = header.validateHeader()
+			}
+			if err != nil {
+				problems = append(problems, Problem{value.Line, err.Error()})
+			}
+			continue
+		case "mappings":
+		default:
+			problems = append(problems, Problem{key.Line, fmt.Sprintf("unknown field \"%s\"", key.Value)})
+			continue
+		}
+		if value.Kind != yamlv3.SequenceNode {
+			problems = append(problems, Problem{value.Line, "mappings must be a list"})
+			continue
+		}
+		mappingsLine = key.Line
+		entries = value.Content
+	}
+
+	linter := &linter{first: make(map[string]*lintedMapping)}
+	for _, entry := range entries {
+		problems = append(problems, linter.lintEntry(entry)...)
+	}
+
+	// cycles are only looked for once the mappings are otherwise valid, as conflicts are already reported
+	if len(problems) == 0 {
+		if err := (&Metadata{Mappings: linter.mappings}).Validate(); err != nil {
+			problems = append(problems, Problem{mappingsLine, err.Error()})
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// linter checks the mappings of a map file one after the other
+type linter struct {
+	// mappings are the mappings which were decoded
+	mappings []*Mapping
+	// first is the first mapping of each deprecated API
+	first map[string]*lintedMapping
+}
+
+// lintedMapping is a mapping with the line of its entry
+type lintedMapping struct {
+	*Mapping
+	line int
+}
+
+// lintEntry returns the problems of one mapping entry
+func (l *linter) lintEntry(entry *yamlv3.Node) []Problem {
+	if entry.Kind != yamlv3.MappingNode {
+		return []Problem{{entry.Line, "the mapping is not a YAML map"}}
+	}
+	var problems []Problem
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, Problem{line, fmt.Sprintf(format, args...)})
+	}
+
+	// the line of each field, to report problems of a field at its value
+	lines := make(map[string]int)
+	var transformLines []int
+	for i := 0; i+1 < len(entry.Content); i += 2 {
+		key, value := entry.Content[i], entry.Content[i+1]
+		lines[key.Value] = value.Line
+		if key.Value == "transforms" {
+			for _, transform := range value.Content {
+				transformLines = append(transformLines, transform.Line)
+			}
+		}
+	}
+	lineOf := func(field string) int {
+		if line, ok := lines[field]; ok {
+			return line
+		}
+		return entry.Line
+	}
+
+	raw, err := yamlv3.Marshal(entry)
+	if err != nil {
+		return []Problem{{entry.Line, err.Error()}}
+	}
+	m := new(Mapping)
+	if err := yaml.UnmarshalStrict(raw, m); err != nil {
+		return []Problem{{entry.Line, strings.TrimPrefix(err.Error(), "error unmarshaling JSON: while decoding JSON: json: ")}}
+	}
+
+	hasString := m.DeprecatedAPI != "" || m.NewAPI != ""
+	hasStructured := m.Group != "" || m.Version != "" || m.Kind != "" || m.NewGroup != "" || m.NewVersion != ""
+	switch {
+	case hasString && hasStructured:
+		report(entry.Line, "the mapping mixes the deprecatedAPI and newAPI strings with the group, version and kind rule")
+		return problems
+	case m.IsStructured():
+		if m.Version == "" {
+			report(entry.Line, "version is required")
+		}
+		if m.NewVersion != "" && m.NewAPIVersion() == m.APIVersion() {
+			report(lineOf("newVersion"), "the new API is the same as the deprecated API %s", m.APIVersion())
+		}
+	case hasStructured:
+		report(entry.Line, "kind is required")
+		return problems
+	default:
+		if m.DeprecatedAPI == "" {
+			report(entry.Line, "deprecatedAPI is required")
+			return problems
+		}
+		problems = append(problems, lintAPIString("deprecatedAPI", m.DeprecatedAPI, lineOf("deprecatedAPI"))...)
+		if m.NewAPI != "" {
+			problems = append(problems, lintAPIString("newAPI", m.NewAPI, lineOf("newAPI"))...)
+			if m.NewAPIKey() == m.DeprecatedAPIKey() {
+				report(lineOf("newAPI"), "the new API is the same as the deprecated API %s", m.DeprecatedAPIKey())
+			}
+		}
+	}
+
+	for _, field := range []struct{ name, version string }{
+		{"deprecatedInVersion", m.DeprecatedInVersion},
+		{"removedInVersion", m.RemovedInVersion},
+	} {
+		if field.version != "" && !semver.IsValid(field.version) {
+			report(lineOf(field.name), "%s \"%s\" is not a valid Kubernetes version, such as \"v1.22\"", field.name, field.version)
+		}
+	}
+	switch {
+	case m.DeprecatedInVersion == "" && m.RemovedInVersion == "":
+		report(entry.Line, "deprecatedInVersion or removedInVersion is required")
+	case semver.IsValid(m.DeprecatedInVersion) && semver.IsValid(m.RemovedInVersion) &&
+		semver.Compare(m.DeprecatedInVersion, m.RemovedInVersion) > 0:
+		report(lineOf("deprecatedInVersion"), "deprecatedInVersion \"%s\" is later than removedInVersion \"%s\"", m.DeprecatedInVersion, m.RemovedInVersion)
+	}
+
+	for i, transform := range m.Transforms {
+		problems = append(problems, lintTransform(i+1, transform, transformLines[i])...)
+	}
+
+	from := m.DeprecatedAPIKey()
+	if first, ok := l.first[from]; ok {
+		if first.NewAPIKey() == m.NewAPIKey() && first.DeprecatedInVersion == m.DeprecatedInVersion && first.RemovedInVersion == m.RemovedInVersion {
+			report(entry.Line, "duplicate of the mapping at line %d for API %s", first.line, from)
+		} else {
+			report(entry.Line, "conflicts with the mapping at line %d for API %s", first.line, from)
+		}
+	} else {
+		l.first[from] = &lintedMapping{m, entry.Line}
+	}
+	l.mappings = append(l.mappings, m)
+	return problems
+}
+
+// lintAPIString returns the problems of a deprecatedAPI or newAPI string
+func lintAPIString(field, api string, line int) []Problem {
+	var problems []Problem
+	if !strings.HasSuffix(api, "\n") {
+		problems = append(problems, Problem{line, fmt.Sprintf("%s must end with a line feed (\\n), otherwise it also matches kinds which start with the same name", field)})
+	}
+	if apiStringKey(api) == strings.TrimSpace(api) {
+		problems = append(problems, Problem{line, fmt.Sprintf("%s must have an apiVersion and a kind, such as \"apiVersion: apps/v1\\nkind: Deployment\\n\"", field)})
+	}
+	return problems
+}
+
+// lintTransform returns the problems of the n-th transform of a mapping
+func lintTransform(n int, transform Transform, line int) []Problem {
+	var problems []Problem
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{line, fmt.Sprintf("transform %d: ", n) + fmt.Sprintf(format, args...)})
+	}
+	switch transform.Op {
+	case TransformMove, TransformCopy:
+		if !strings.HasPrefix(transform.From, "/") {
+			report("from must be a JSON pointer, such as /spec/selector")
+		}
+	case TransformAdd:
+		if transform.Value == nil {
+			report("value is required")
+		}
+	case TransformRemove:
+	default:
+		report("unknown operation \"%s\", must be one of: %s, %s, %s, %s", transform.Op, TransformMove, TransformCopy, TransformAdd, TransformRemove)
+	}
+	if !strings.HasPrefix(transform.Path, "/") {
+		report("path must be a JSON pointer, such as /spec/selector")
+	}
+	if transform.IfAbsent != "" && !strings.HasPrefix(transform.IfAbsent, "/") {
+		report("ifAbsent must be a JSON pointer, such as /spec/selector")
+	}
+	switch transform.Type {
+	case "", "string", "number", "boolean", "object", "array":
+	default:
+		report("unknown type \"%s\", must be one of: string, number, boolean, object, array", transform.Type)
+	}
+	return problems
+}
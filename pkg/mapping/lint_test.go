@@ -0,0 +1,71 @@
+package mapping_test
+
+import (
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("linting a map file", func() {
+	ginkgo.It("finds no problems in the default map file", func() {
+		problems, err := mapping.LintMapfile("../../config/Map.yaml")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(problems).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("reports every problem with its line", func() {
+		problems := mapping.Lint([]byte(`mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    deprecatedInVersion: "1.22"
+    removedInVersion: "v1.25"
+  - deprecatedAPI: "apiVersion: example.com/v1\nkind: Gadget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Gadget\n"
+    deprecatedInVersion: "v1.26"
+    removedInVersion: "v1.25"
+  - deprecatedAPI: "apiVersion: example.com/v1\nkind: Gadget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Gadget\n"
+    deprecatedInVersion: "v1.26"
+    removedInVersion: "v1.25"
+  - group: example.com
+    version: v1alpha1
+    kind: Widget
+    newVersion: v1
+    removedInVersion: "v1.20"
+    transforms:
+      - op: rename
+        path: /spec/size
+        ifAbsent: spec
+`))
+		var messages []string
+		for _, problem := range problems {
+			messages = append(messages, problem.String())
+		}
+		gomega.Expect(messages).To(gomega.Equal([]string{
+			`line 2: deprecatedAPI must end with a line feed (\n), otherwise it also matches kinds which start with the same name`,
+			`line 4: deprecatedInVersion "1.22" is not a valid Kubernetes version, such as "v1.22"`,
+			`line 7: the new API is the same as the deprecated API example.com/v1, Kind=Gadget`,
+			`line 8: deprecatedInVersion "v1.26" is later than removedInVersion "v1.25"`,
+			`line 10: duplicate of the mapping at line 6 for API example.com/v1, Kind=Gadget`,
+			`line 11: the new API is the same as the deprecated API example.com/v1, Kind=Gadget`,
+			`line 12: deprecatedInVersion "v1.26" is later than removedInVersion "v1.25"`,
+			`line 20: transform 1: unknown operation "rename", must be one of: move, copy, add, remove`,
+			`line 20: transform 1: ifAbsent must be a JSON pointer, such as /spec/selector`,
+		}))
+	})
+
+	ginkgo.It("reports mappings which form a cycle", func() {
+		problems := mapping.Lint([]byte(`mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.25"
+  - deprecatedAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    removedInVersion: "v1.30"
+`))
+		gomega.Expect(problems).To(gomega.HaveLen(1))
+		gomega.Expect(problems[0].Line).To(gomega.Equal(1))
+		gomega.Expect(problems[0].Message).To(gomega.ContainSubstring("mappings form a cycle"))
+	})
+})
@@ -17,18 +17,64 @@ limitations under the License.
 package mapping
 
 import (
+	"fmt"
 	"os"
 
 	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/config"
 )
 
+// DefaultMapfileName is the name the default mapping file, which is compiled into the plugin, is reported as
+const DefaultMapfileName = "<default>"
+
 // LoadMapfile loads a Map.yaml file into a *Metadata.
+// The mappings are validated so that conflicting mappings and cycles are rejected.
 func LoadMapfile(filename string) (*Metadata, error) {
 	b, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	return ParseMapfile(b)
+}
+
+// LoadDefaultMapfile loads the default mapping file, which is compiled into the plugin
+func LoadDefaultMapfile() (*Metadata, error) {
+	return ParseMapfile(config.DefaultMapfile)
+}
+
+// ParseMapfile parses the content of a Map.yaml file into a *Metadata.
+// The mappings are validated so that conflicting mappings and cycles are rejected.
+func ParseMapfile(data []byte) (*Metadata, error) {
 	y := new(Metadata)
-	err = yaml.Unmarshal(b, y)
-	return y, err
+	if err := yaml.Unmarshal(data, y); err != nil {
+		return y, err
+	}
+	return y, y.Validate()
+}
+
+// LoadMapfiles loads the default mapping file, unless withDefault is false, and then each of the
+// mapping files in order. Each file is layered over the files before it with Merge, so a mapping
+// of a later file replaces the mapping of the same API of an earlier file.
+func LoadMapfiles(withDefault bool, filenames ...string) (*Metadata, error) {
+	merged := new(Metadata)
+	if withDefault {
+		defaults, err := LoadDefaultMapfile()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", DefaultMapfileName, err)
+		}
+		merged.Merge(defaults)
+	}
+	for _, filename := range filenames {
+		layer, err := LoadMapfile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		merged.Merge(layer)
+	}
+	// the layers are valid on their own, but a cycle may go through several of them
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+	return merged, nil
 }
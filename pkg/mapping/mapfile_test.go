@@ -0,0 +1,121 @@
+package mapping_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("layering map files", func() {
+	writeMapFile := func(content string) string {
+		mapFileName := filepath.Join(ginkgo.GinkgoT().TempDir(), "Map.yaml")
+		gomega.Expect(os.WriteFile(mapFileName, []byte(content), 0o600)).To(gomega.Succeed())
+		return mapFileName
+	}
+
+	// findMapping returns the mapping of the deprecated API, or nil
+	findMapping := func(mapMetadata *mapping.Metadata, key string) *mapping.Mapping {
+		for _, m := range mapMetadata.Mappings {
+			if m.DeprecatedAPIKey() == key {
+				return m
+			}
+		}
+		return nil
+	}
+
+	ginkgo.It("loads the default map file compiled into the plugin", func() {
+		defaults, err := mapping.LoadDefaultMapfile()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		fromFile, err := mapping.LoadMapfile("../../config/Map.yaml")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(defaults).To(gomega.Equal(fromFile))
+	})
+
+	ginkgo.It("replaces mappings of the same API and appends new mappings in file order", func() {
+		orgWide := writeMapFile(`mappings:
+  - group: policy
+    version: v1beta1
+    kind: PodSecurityPolicy
+    removedInVersion: "v1.30"
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.25"
+`)
+		team := writeMapFile(`mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v2\nkind: Widget\n"
+    removedInVersion: "v1.27"
+`)
+		defaults, err := mapping.LoadDefaultMapfile()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		merged, err := mapping.LoadMapfiles(true, orgWide, team)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(merged.Mappings).To(gomega.HaveLen(len(defaults.Mappings) + 1))
+
+		psp := findMapping(merged, "policy/v1beta1, Kind=PodSecurityPolicy")
+		gomega.Expect(psp).ToNot(gomega.BeNil())
+		gomega.Expect(psp.RemovedInVersion).To(gomega.Equal("v1.30"))
+
+		widget := findMapping(merged, "example.com/v1beta1, Kind=Widget")
+		gomega.Expect(widget).ToNot(gomega.BeNil())
+		gomega.Expect(widget.NewAPIKey()).To(gomega.Equal("example.com/v2, Kind=Widget"))
+	})
+
+	ginkgo.It("only loads the given files without the default map file", func() {
+		merged, err := mapping.LoadMapfiles(false, writeMapFile(`mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.25"
+`))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(merged.Mappings).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("rejects a cycle through several files", func() {
+		_, err := mapping.LoadMapfiles(true, writeMapFile(`mappings:
+  - deprecatedAPI: "apiVersion: apps/v1\nkind: Deployment\n"
+    newAPI: "apiVersion: extensions/v1beta1\nkind: Deployment\n"
+    removedInVersion: "v1.40"
+`))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("mappings form a cycle")))
+	})
+
+	ginkgo.It("names the file which fails to load", func() {
+		mapFileName := writeMapFile("mappings: [")
+		_, err := mapping.LoadMapfiles(true, mapFileName)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(mapFileName)))
+	})
+})
+
+var _ = ginkgo.Describe("the map file metadata", func() {
+	ginkgo.It("checks the Kubernetes version covered by the map file", func() {
+		mapMetadata := &mapping.Metadata{CoversKubeVersion: "v1.29"}
+		gomega.Expect(mapMetadata.CheckCoverage("v1.29.4")).To(gomega.Succeed())
+		gomega.Expect(mapMetadata.CheckCoverage("v1.27.0")).To(gomega.Succeed())
+		gomega.Expect(mapMetadata.CheckCoverage("v1.32.0")).To(gomega.MatchError("map file covers up to v1.29 but cluster is v1.32"))
+		gomega.Expect((&mapping.Metadata{}).CheckCoverage("v1.27.0")).ToNot(gomega.Succeed())
+	})
+
+	ginkgo.It("keeps the lowest covered Kubernetes version and the last data version when layering", func() {
+		merged := &mapping.Metadata{DataVersion: "1", Date: "2024-01-01", CoversKubeVersion: "v1.32"}
+		merged.Merge(&mapping.Metadata{DataVersion: "7", CoversKubeVersion: "v1.29"})
+		merged.Merge(&mapping.Metadata{CoversKubeVersion: "v1.30"})
+		gomega.Expect(merged.DataVersion).To(gomega.Equal("7"))
+		gomega.Expect(merged.Date).To(gomega.Equal("2024-01-01"))
+		gomega.Expect(merged.CoversKubeVersion).To(gomega.Equal("v1.29"))
+	})
+
+	ginkgo.It("rejects an unsupported schema version", func() {
+		_, err := mapping.ParseMapfile([]byte("schemaVersion: v2\nmappings: []\n"))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unsupported schemaVersion")))
+
+		problems := mapping.Lint([]byte("schemaVersion: v2\ndate: 11/12/2024\nmappings: []\n"))
+		gomega.Expect(problems).To(gomega.HaveLen(2))
+		gomega.Expect(problems[1].String()).To(gomega.Equal(`line 2: date "11/12/2024" is not a date such as "2024-12-11"`))
+	})
+})
@@ -16,18 +16,142 @@ limitations under the License.
 
 package mapping
 
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// WildcardKind matches every kind of a group and version
+const WildcardKind = "*"
+
 // Mapping describes mappings which defines the Kubernetes
-// API deprecations and the new replacement API
+// API deprecations and the new replacement API.
+// The APIs are either set as strings in DeprecatedAPI and NewAPI, or as
+// structured rules in Group, Version, Kind, NewGroup and NewVersion.
 type Mapping struct {
 	// From is the API looking to be mapped
-	DeprecatedAPI string `json:"deprecatedAPI"`
+	DeprecatedAPI string `json:"deprecatedAPI,omitempty"`
 
 	// To is the API to be mapped to
-	NewAPI string `json:"newAPI"`
+	NewAPI string `json:"newAPI,omitempty"`
+
+	// Group of the API looking to be mapped, empty for the core group
+	Group string `json:"group,omitempty"`
+
+	// Version of the API looking to be mapped
+	Version string `json:"version,omitempty"`
+
+	// Kind of the API looking to be mapped, or "*" for every kind of the group and version
+	Kind string `json:"kind,omitempty"`
+
+	// Group to be mapped to, defaults to Group
+	NewGroup string `json:"newGroup,omitempty"`
+
+	// Version to be mapped to, empty if the API has no successor
+	NewVersion string `json:"newVersion,omitempty"`
 
 	// Kubernetes version API is deprecated in
 	DeprecatedInVersion string `json:"deprecatedInVersion,omitempty"`
 
 	// Kubernetes version API is removed in
 	RemovedInVersion string `json:"removedInVersion,omitempty"`
+
+	// Transforms are applied in order to the resources which are mapped to the new API
+	Transforms []Transform `json:"transforms,omitempty"`
+}
+
+// IsStructured returns true if the mapping is a structured rule instead of API strings
+func (m *Mapping) IsStructured() bool {
+	return m.Kind != ""
+}
+
+// IsWildcard returns true if the mapping is a structured rule for every kind of a group and version
+func (m *Mapping) IsWildcard() bool {
+	return m.Kind == WildcardKind
+}
+
+// APIVersion returns the apiVersion of a structured rule
+func (m *Mapping) APIVersion() string {
+	return apiVersion(m.Group, m.Version)
+}
+
+// NewAPIVersion returns the apiVersion to be mapped to of a structured rule,
+// or an empty string if the API has no successor
+func (m *Mapping) NewAPIVersion() string {
+	if m.NewVersion == "" {
+		return ""
+	}
+	newGroup := m.NewGroup
+	if newGroup == "" {
+		newGroup = m.Group
+	}
+	return apiVersion(newGroup, m.NewVersion)
+}
+
+// ForKind returns a copy of a structured rule for the given kind, with the DeprecatedAPI
+// and NewAPI strings set. A mapping which is not a structured rule is returned as is.
+func (m *Mapping) ForKind(kind string) *Mapping {
+	if !m.IsStructured() {
+		return m
+	}
+	mapping := *m
+	mapping.Kind = kind
+	mapping.DeprecatedAPI = apiString(m.APIVersion(), kind)
+	if newAPIVersion := m.NewAPIVersion(); newAPIVersion != "" {
+		mapping.NewAPI = apiString(newAPIVersion, kind)
+	}
+	return &mapping
+}
+
+// DeprecatedAPIKey returns the API looking to be mapped, as "<apiVersion>, Kind=<kind>"
+func (m *Mapping) DeprecatedAPIKey() string {
+	if m.IsStructured() {
+		return apiKey(m.APIVersion(), m.Kind)
+	}
+	return apiStringKey(m.DeprecatedAPI)
+}
+
+// NewAPIKey returns the API to be mapped to, as "<apiVersion>, Kind=<kind>", or an empty
+// string if the API has no successor
+func (m *Mapping) NewAPIKey() string {
+	if m.IsStructured() {
+		if newAPIVersion := m.NewAPIVersion(); newAPIVersion != "" {
+			return apiKey(newAPIVersion, m.Kind)
+		}
+		return ""
+	}
+	if m.NewAPI == "" {
+		return ""
+	}
+	return apiStringKey(m.NewAPI)
+}
+
+func apiKey(apiVersion, kind string) string {
+	return fmt.Sprintf("%s, Kind=%s", apiVersion, kind)
+}
+
+// apiStringKey returns the key of an API string, or the trimmed string if it cannot be decoded
+func apiStringKey(api string) string {
+	var header struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := yaml.Unmarshal([]byte(api), &header); err != nil || header.APIVersion == "" || header.Kind == "" {
+		return strings.TrimSpace(api)
+	}
+	return apiKey(header.APIVersion, header.Kind)
+}
+
+func apiVersion(group, version string) string {
+	if group == "" {
+		return version
+	}
+	return group + "/" + version
+}
+
+// apiString returns the API string as used in DeprecatedAPI and NewAPI
+func apiString(apiVersion, kind string) string {
+	return fmt.Sprintf("apiVersion: %s\nkind: %s\n", apiVersion, kind)
 }
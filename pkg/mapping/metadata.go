@@ -16,8 +16,135 @@ limitations under the License.
 
 package mapping
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// SchemaVersion is the version of the Mapping.yaml format which is supported
+const SchemaVersion = "v1"
+
 // Metadata for a Mapping file. This models the structure of a Mapping.yaml file.
 type Metadata struct {
+	// SchemaVersion is the version of the format of the file, SchemaVersion if unset.
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+
+	// DataVersion is the version of the mappings in the file.
+	DataVersion string `json:"dataVersion,omitempty"`
+
+	// Date is the date the mappings were last updated, such as "2024-12-11".
+	Date string `json:"date,omitempty"`
+
+	// CoversKubeVersion is the latest Kubernetes version whose deprecated and removed APIs are in the file.
+	CoversKubeVersion string `json:"coversKubeVersion,omitempty"`
+
 	// Mappings are a list of mappings.
 	Mappings []*Mapping `json:"mappings,omitempty"`
 }
+
+// Merge layers the mappings of other over the mappings. A mapping of other replaces the mapping of
+// the same deprecated API in place, and the other mappings of other are appended in their order.
+// An API with a structured rule for its kind is matched by that rule instead of a wildcard rule of its
+// group and version, so a wildcard rule is refined rather than replaced by a rule for a single kind.
+//
+// The data version and date are those of the last layer which sets them. The layers only cover the
+// Kubernetes version which all of them cover, so the lowest covered Kubernetes version is kept.
+func (m *Metadata) Merge(other *Metadata) {
+	if other.DataVersion != "" {
+		m.DataVersion = other.DataVersion
+	}
+	if other.Date != "" {
+		m.Date = other.Date
+	}
+	if other.CoversKubeVersion != "" &&
+		(m.CoversKubeVersion == "" || semver.Compare(other.CoversKubeVersion, m.CoversKubeVersion) < 0) {
+		m.CoversKubeVersion = other.CoversKubeVersion
+	}
+
+	index := make(map[string]int)
+	for i, mapping := range m.Mappings {
+		index[mapping.DeprecatedAPIKey()] = i
+	}
+	for _, mapping := range other.Mappings {
+		key := mapping.DeprecatedAPIKey()
+		if i, ok := index[key]; ok {
+			m.Mappings[i] = mapping
+			continue
+		}
+		index[key] = len(m.Mappings)
+		m.Mappings = append(m.Mappings, mapping)
+	}
+}
+
+// Validate checks that the mappings can be applied in any order with the same result. An API must not be
+// mapped differently by two mappings, and following the mappings from an API must not lead back to it.
+func (m *Metadata) Validate() error {
+	if err := m.validateHeader(); err != nil {
+		return err
+	}
+
+	// the first mapping of each API
+	first := make(map[string]int)
+	for i, mapping := range m.Mappings {
+		from := mapping.DeprecatedAPIKey()
+		j, ok := first[from]
+		if !ok {
+			first[from] = i
+			continue
+		}
+		other := m.Mappings[j]
+		if mapping.NewAPIKey() != other.NewAPIKey() ||
+			mapping.DeprecatedInVersion != other.DeprecatedInVersion || mapping.RemovedInVersion != other.RemovedInVersion {
+			return fmt.Errorf("conflicting mappings %d and %d for API %s", j+1, i+1, from)
+		}
+	}
+
+	// follow the mappings from each API, which is a chain as each API has a single mapping
+	for _, i := range first {
+		visited := []string{m.Mappings[i].DeprecatedAPIKey()}
+		for next := m.Mappings[i].NewAPIKey(); next != ""; {
+			visited = append(visited, next)
+			if next == visited[0] {
+				return fmt.Errorf("mappings form a cycle: %s", strings.Join(visited, " -> "))
+			}
+			j, ok := first[next]
+			if !ok || len(visited) > len(first) {
+				break
+			}
+			next = m.Mappings[j].NewAPIKey()
+		}
+	}
+	return nil
+}
+
+// validateHeader checks the fields which describe the file
+func (m *Metadata) validateHeader() error {
+	if m.SchemaVersion != "" && m.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("unsupported schemaVersion \"%s\", the supported version is \"%s\"", m.SchemaVersion, SchemaVersion)
+	}
+	if m.Date != "" {
+		if _, err := time.Parse(time.DateOnly, m.Date); err != nil {
+			return fmt.Errorf("date \"%s\" is not a date such as \"2024-12-11\"", m.Date)
+		}
+	}
+	if m.CoversKubeVersion != "" && !semver.IsValid(m.CoversKubeVersion) {
+		return fmt.Errorf("coversKubeVersion \"%s\" is not a valid Kubernetes version, such as \"v1.29\"", m.CoversKubeVersion)
+	}
+	return nil
+}
+
+// CheckCoverage returns an error if the mappings are not known to cover the Kubernetes version, as the
+// APIs deprecated or removed in later Kubernetes versions than the covered version are missing
+func (m *Metadata) CheckCoverage(kubeVersion string) error {
+	if m.CoversKubeVersion == "" {
+		return fmt.Errorf("map file does not set the Kubernetes version it covers with coversKubeVersion")
+	}
+	covered, kube := semver.MajorMinor(m.CoversKubeVersion), semver.MajorMinor(kubeVersion)
+	if semver.Compare(kube, covered) > 0 {
+		return fmt.Errorf("map file covers up to %s but cluster is %s", covered, kube)
+	}
+	return nil
+}
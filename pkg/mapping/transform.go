@@ -0,0 +1,58 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+// TransformOp is the operation of a field transformation
+type TransformOp string
+
+const (
+	// TransformMove moves the field at From to Path, unless Path is already set
+	TransformMove TransformOp = "move"
+	// TransformCopy copies the field at From to Path, unless Path is already set
+	TransformCopy TransformOp = "copy"
+	// TransformAdd sets the field at Path to Value, unless Path is already set
+	TransformAdd TransformOp = "add"
+	// TransformRemove removes the field at Path
+	TransformRemove TransformOp = "remove"
+)
+
+// Transform describes a change to the fields of a resource which is needed
+// because the schema of the new API differs from the deprecated API.
+// Paths are JSON pointers, such as "/spec/backend/serviceName", in which a "*"
+// segment matches every item of a list or every value of a map. The "*" segments
+// of Path are set to what the "*" segments of From matched, in order.
+type Transform struct {
+	// Op is the operation to perform
+	Op TransformOp `json:"op"`
+
+	// From is the field to move or copy
+	From string `json:"from,omitempty"`
+
+	// Path is the field to set or remove
+	Path string `json:"path"`
+
+	// Value is the value to add
+	Value interface{} `json:"value,omitempty"`
+
+	// Type restricts the transformation to fields of the given type:
+	// string, number, boolean, object or array
+	Type string `json:"type,omitempty"`
+
+	// IfAbsent is a field which must not be set for the transformation to be applied,
+	// such as "/spec/selector" to only add a selector to resources which have none
+	IfAbsent string `json:"ifAbsent,omitempty"`
+}
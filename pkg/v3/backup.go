@@ -0,0 +1,291 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	backupManagedByLabel = "app.kubernetes.io/managed-by"
+	backupManagedBy      = "mapkubeapis"
+	backupNameLabel      = "mapkubeapis.helm.sh/release"
+	backupVersionLabel   = "mapkubeapis.helm.sh/version"
+	backupDataKey        = "backup"
+)
+
+// Backup is the record of a release version before it was mapped, which is used to
+// restore the release to the state before the mapping
+type Backup struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Created   time.Time `json:"created"`
+
+	// Version is the release version which was mapped
+	Version int `json:"version"`
+
+	// NewVersion is the release version added with the mapped APIs
+	NewVersion int `json:"newVersion"`
+
+	// Release is the original release version, encoded as in the Helm storage
+	Release string `json:"release"`
+}
+
+// BackupStore saves and loads release backups
+type BackupStore interface {
+	// Save stores the backup and returns a description of where it was stored
+	Save(backup *Backup) (string, error)
+	// Latest returns the backup of the highest release version of a release
+	Latest(name, namespace string) (*Backup, error)
+	// Delete removes the backup
+	Delete(backup *Backup) error
+}
+
+// ErrBackupNotFound is returned when no backup exists for a release
+var ErrBackupNotFound = errors.New("no backup found")
+
+// NewBackupStore returns the backup store configured in the map options. Backups are stored
+// in ConfigMaps in the release namespace if set, otherwise in the backup directory.
+func NewBackupStore(mapOptions common.MapOptions, cfg *action.Configuration) (BackupStore, error) {
+	if mapOptions.BackupConfigMap {
+		clientSet, err := common.NewClientSet(cfg.RESTClientGetter)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get Kubernetes client")
+		}
+		return &configMapBackupStore{clientSet: clientSet}, nil
+	}
+	if mapOptions.BackupDir == "" {
+		return nil, errors.New("backup directory is not set")
+	}
+	return &dirBackupStore{dir: mapOptions.BackupDir}, nil
+}
+
+// newBackup returns a backup of the release version, before it is updated by adding the new release version
+func newBackup(rel *release.Release, newVersion int, now time.Time) (*Backup, error) {
+	encoded, err := encodeRelease(rel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode release version '%s'", getReleaseVersionName(rel))
+	}
+	return &Backup{
+		Name:       rel.Name,
+		Namespace:  rel.Namespace,
+		Created:    now,
+		Version:    rel.Version,
+		NewVersion: newVersion,
+		Release:    encoded,
+	}, nil
+}
+
+// Decode returns the original release version stored in the backup
+func (b *Backup) Decode() (*release.Release, error) {
+	return decodeRelease(b.Release)
+}
+
+// encodeRelease encodes a release the same way as the Helm storage drivers do:
+// JSON, compressed with gzip and encoded with base64
+func encodeRelease(rel *release.Release) (string, error) {
+	b, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(b); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeRelease decodes a release encoded by encodeRelease
+func decodeRelease(data string) (*release.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if b, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	var rel release.Release
+	if err := json.Unmarshal(b, &rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// dirBackupStore stores backups as JSON files in a local directory
+type dirBackupStore struct {
+	dir string
+}
+
+func (s *dirBackupStore) path(name, namespace string, version int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s.%s.v%d.json", namespace, name, version))
+}
+
+func (s *dirBackupStore) Save(backup *Backup) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := s.path(backup.Name, backup.Namespace, backup.Version)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *dirBackupStore) Latest(name, namespace string) (*Backup, error) {
+	prefix := fmt.Sprintf("%s.%s.v", namespace, name)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	latest := -1
+	for _, entry := range entries {
+		version, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSuffix(version, ".json"))
+		if err == nil && v > latest {
+			latest = v
+		}
+	}
+	if latest == -1 {
+		return nil, ErrBackupNotFound
+	}
+
+	b, err := os.ReadFile(s.path(name, namespace, latest))
+	if err != nil {
+		return nil, err
+	}
+	var backup Backup
+	if err := json.Unmarshal(b, &backup); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+func (s *dirBackupStore) Delete(backup *Backup) error {
+	return os.Remove(s.path(backup.Name, backup.Namespace, backup.Version))
+}
+
+// configMapBackupStore stores backups in ConfigMaps in the release namespace
+type configMapBackupStore struct {
+	clientSet kubernetes.Interface
+}
+
+func configMapName(name string, version int) string {
+	return fmt.Sprintf("mapkubeapis.backup.%s.v%d", name, version)
+}
+
+func (s *configMapBackupStore) Save(backup *Backup) (string, error) {
+	b, err := json.Marshal(backup)
+	if err != nil {
+		return "", err
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(backup.Name, backup.Version),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				backupManagedByLabel: backupManagedBy,
+				backupNameLabel:      backup.Name,
+				backupVersionLabel:   strconv.Itoa(backup.Version),
+			},
+		},
+		Data: map[string]string{backupDataKey: string(b)},
+	}
+	configMaps := s.clientSet.CoreV1().ConfigMaps(backup.Namespace)
+	if _, err := configMaps.Create(context.Background(), configMap, metav1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return "", err
+		}
+		if _, err := configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{}); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("configmap/%s in namespace '%s'", configMap.Name, configMap.Namespace), nil
+}
+
+func (s *configMapBackupStore) Latest(name, namespace string) (*Backup, error) {
+	list, err := s.clientSet.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", backupManagedByLabel, backupManagedBy, backupNameLabel, name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *v1.ConfigMap
+	latestVersion := -1
+	for i := range list.Items {
+		v, err := strconv.Atoi(list.Items[i].Labels[backupVersionLabel])
+		if err == nil && v > latestVersion {
+			latest, latestVersion = &list.Items[i], v
+		}
+	}
+	if latest == nil {
+		return nil, ErrBackupNotFound
+	}
+
+	var backup Backup
+	if err := json.Unmarshal([]byte(latest.Data[backupDataKey]), &backup); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+func (s *configMapBackupStore) Delete(backup *Backup) error {
+	return s.clientSet.CoreV1().ConfigMaps(backup.Namespace).Delete(context.Background(),
+		configMapName(backup.Name, backup.Version), metav1.DeleteOptions{})
+}
@@ -0,0 +1,47 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// CheckRelease checks the latest release version for any deprecated or removed APIs in its metadata, without
+// updating the release or taking a backup. The release status in the report is clean, deprecated or removed.
+func CheckRelease(mapOptions common.MapOptions, additionalMappings ...*mapping.Mapping) (*common.ReleaseReport, error) {
+	report := newReleaseReport(mapOptions)
+	report.DryRun = false
+
+	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	// the release is only read, so the mapper is in dry-run mode, which also takes no backup
+	mapOptions.DryRun = true
+	mapper, err := newReleaseMapper(mapOptions, cfg, additionalMappings...)
+	if err != nil {
+		return report, err
+	}
+	if report, err = mapper.Check(mapOptions.ReleaseName); report.Namespace == "" {
+		report.Namespace = mapOptions.ReleaseNamespace
+	}
+	return report, err
+}
@@ -20,44 +20,147 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/yaml"
 
 	common "github.com/helm/helm-mapkubeapis/pkg/common"
 )
 
-var (
-	settings = cli.New()
-)
-
 // GetActionConfig returns action configuration based on Helm env
 func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
-
-	// Add kube config settings passed by user
-	settings.KubeConfig = kubeConfig.File
-	settings.KubeContext = kubeConfig.Context
+	settings := common.NewEnvSettings(kubeConfig)
 
 	// check if the namespace is passed by the user. If not get Helm to return the current namespace
 	if namespace == "" {
 		namespace = settings.Namespace()
 	}
 
-	err := actionConfig.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), debug)
+	return initActionConfig(settings, namespace, kubeConfig)
+}
+
+// GetActionConfigAllNamespaces returns action configuration based on Helm env, with access
+// to the releases in all namespaces
+func GetActionConfigAllNamespaces(kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	return initActionConfig(common.NewEnvSettings(kubeConfig), "", kubeConfig)
+}
+
+// initActionConfig returns the action configuration of the namespace. Its REST client getter, which
+// is built from the Helm env, is used for every client of the cluster, including the release storage.
+// The release storage uses the storage driver and connection of the Kubernetes configuration.
+func initActionConfig(settings *cli.EnvSettings, namespace string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	helmDriver := kubeConfig.StorageDriver
+	if helmDriver == "" {
+		helmDriver = os.Getenv("HELM_DRIVER")
+	}
+	logger := debugLog(settings)
+
+	releases, err := newReleaseStorage(helmDriver, kubeConfig.StorageConnection, namespace, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	return actionConfig, err
+	// Helm sets up the storage of the driver, unless the plugin has its own storage for it. Helm
+	// then sets up the memory driver instead, which does not connect to anything.
+	initDriver := helmDriver
+	if releases != nil {
+		initDriver = "memory"
+	}
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, initDriver, logger); err != nil {
+		return nil, err
+	}
+	if releases != nil {
+		actionConfig.Releases = releases
+	}
+	return actionConfig, nil
 }
 
-func debug(format string, v ...interface{}) {
-	if settings.Debug {
-		format = fmt.Sprintf("[debug] %s\n", format)
-		err := log.Output(2, fmt.Sprintf(format, v...))
+// newReleaseStorage returns the release storage of the driver where it differs from the one Helm sets up,
+// or nil where it does not. Helm only reads the connection of the sql driver from HELM_DRIVER_SQL_CONNECTION_STRING
+// and does not load the releases of HELM_MEMORY_DRIVER_DATA, which the Helm CLI does instead.
+func newReleaseStorage(helmDriver, connection, namespace string, logger action.DebugLog) (*storage.Storage, error) {
+	switch helmDriver {
+	case "secret", "secrets", "", "configmap", "configmaps":
+		if connection != "" {
+			return nil, errors.Errorf("a storage connection is not supported by the %s driver", driverName(helmDriver))
+		}
+	case "sql":
+		if connection == "" {
+			return nil, nil
+		}
+		d, err := driver.NewSQL(connection, logger, namespace)
 		if err != nil {
-			return
+			return nil, errors.Wrap(err, "unable to instantiate SQL driver")
+		}
+		return storage.Init(d), nil
+	case "memory":
+		if connection == "" {
+			connection = os.Getenv("HELM_MEMORY_DRIVER_DATA")
+		}
+		if connection == "" {
+			return nil, nil
+		}
+		d := driver.NewMemory()
+		if err := loadReleasesInMemory(d, namespace, connection); err != nil {
+			return nil, err
+		}
+		return storage.Init(d), nil
+	}
+	return nil, nil
+}
+
+// driverName returns the name of the Helm storage driver, secret if it is not set
+func driverName(helmDriver string) string {
+	if helmDriver == "" {
+		return "secret"
+	}
+	return helmDriver
+}
+
+// loadReleasesInMemory loads the releases of the files into the memory driver, the same way as Helm
+// does with HELM_MEMORY_DRIVER_DATA. The files are separated by the OS path list separator and are YAML
+// lists of releases.
+func loadReleasesInMemory(mem *driver.Memory, namespace, files string) error {
+	store := storage.Init(mem)
+	for _, path := range filepath.SplitList(files) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "unable to read memory driver data")
+		}
+
+		releases := []*release.Release{}
+		if err := yaml.Unmarshal(b, &releases); err != nil {
+			return errors.Wrapf(err, "unable to unmarshal memory driver data of %s", path)
+		}
+		for _, rel := range releases {
+			if err := store.Create(rel); err != nil {
+				return errors.Wrapf(err, "unable to load release '%s' into memory driver", rel.Name)
+			}
+		}
+	}
+
+	// the releases are created in their own namespace, so the namespace of the driver is set afterwards
+	mem.SetNamespace(namespace)
+	return nil
+}
+
+// debugLog returns the debug log function of the Helm action configuration, which only logs if HELM_DEBUG is set
+func debugLog(settings *cli.EnvSettings) action.DebugLog {
+	return func(format string, v ...interface{}) {
+		if settings.Debug {
+			format = fmt.Sprintf("[debug] %s\n", format)
+			err := log.Output(2, fmt.Sprintf(format, v...))
+			if err != nil {
+				return
+			}
 		}
 	}
 }
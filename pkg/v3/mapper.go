@@ -0,0 +1,337 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// ReleaseStore reads and writes the versions of Helm releases. The storage of a Helm action
+// configuration, *storage.Storage, is a ReleaseStore.
+type ReleaseStore interface {
+	// Last returns the latest version of a release
+	Last(name string) (*release.Release, error)
+	// Deployed returns the latest deployed version of a release
+	Deployed(name string) (*release.Release, error)
+	// Get returns a version of a release
+	Get(name string, version int) (*release.Release, error)
+	// ListReleases returns every version of every release
+	ListReleases() ([]*release.Release, error)
+	// Create stores a new release version
+	Create(rel *release.Release) error
+	// Update stores a changed release version
+	Update(rel *release.Release) error
+}
+
+// releaseDeleter is implemented by release stores which can delete a release version, which is
+// used to roll back a failed update
+type releaseDeleter interface {
+	Delete(name string, version int) (*release.Release, error)
+}
+
+// MapperOptions are the options of a Mapper
+type MapperOptions struct {
+	// DryRun maps the releases without updating them or taking backups
+	DryRun bool
+
+	// Diff adds a unified diff of the mapped resources to the reports
+	Diff bool
+
+	// MapOn is the policy of from which Kubernetes version a deprecated API is mapped, deprecated if unset
+	MapOn common.MapOn
+
+	// Structured decodes each manifest document instead of matching the mapping text
+	Structured bool
+
+	// Strict fails instead of warning when the mapping data does not cover the Kubernetes version
+	Strict bool
+
+	// Revision is the release version to map or check. If unset, the latest deployed version is used.
+	Revision int
+
+	// ServedAPIs are the APIs the cluster serves, which MapToServed and ReportUnserved require
+	ServedAPIs *common.ServedAPIs
+
+	// MapToServed only maps deprecated APIs to new APIs which are in ServedAPIs, such as when mapping
+	// against the target version of a cluster upgrade
+	MapToServed bool
+
+	// ReportUnserved reports the resources whose API is not in ServedAPIs, even if the API is not in
+	// the mapping data
+	ReportUnserved bool
+
+	// Backups stores a backup of each release version before it is superseded, no backup is taken if nil
+	Backups BackupStore
+
+	// NewValidator returns the validator of the mapped manifests for the Kubernetes version. It is only
+	// called for releases which have APIs to map. The manifests are only decoded if it is nil.
+	NewValidator func(kubeVersionStr string) (*common.ManifestValidator, error)
+}
+
+// Mapper maps the deprecated or removed Kubernetes APIs of Helm releases. It only uses the release
+// store, version source, mapping source and options it is built with, so it can be used with any
+// Helm action configuration or release storage, without global settings or environment variables.
+type Mapper struct {
+	releases ReleaseStore
+	versions common.VersionSource
+	mappings common.MappingSource
+	options  MapperOptions
+}
+
+// NewMapper returns a mapper of the releases in the release store. The Kubernetes version and the
+// mapping data are looked up for each release, so a long-lived mapper follows their changes.
+func NewMapper(releases ReleaseStore, versions common.VersionSource, mappings common.MappingSource, options MapperOptions) *Mapper {
+	return &Mapper{
+		releases: releases,
+		versions: versions,
+		mappings: mappings,
+		options:  options,
+	}
+}
+
+// Map checks the latest deployed version of the release, or the version of the options, for deprecated or
+// removed APIs. If it finds any, it adds a new release version with the APIs mapped to supported versions,
+// unless the mapper is in dry-run mode. The new version follows the latest version of the release.
+func (m *Mapper) Map(releaseName string) (*common.ReleaseReport, error) {
+	report := m.newReleaseReport(releaseName)
+
+	releaseToMap, latestRelease, err := m.getRelease(releaseName, true)
+	if err != nil {
+		return report, err
+	}
+	newVersion := latestRelease.Version + 1
+
+	mapper, err := m.newManifestMapper()
+	if err != nil {
+		return report, err
+	}
+
+	origManifest := releaseToMap.Manifest
+	modifiedManifest, modifiedHookManifests, modified, err := m.mapRelease(releaseToMap, mapper, report)
+	if err != nil {
+		return report, err
+	}
+	if !modified {
+		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
+		report.Status = common.StatusClean
+		return report, nil
+	}
+
+	validator := common.NewManifestValidator(nil)
+	if m.options.NewValidator != nil {
+		if validator, err = m.options.NewValidator(mapper.KubeVersion()); err != nil {
+			return report, err
+		}
+	}
+	log.Printf("Validate the mapped manifests of release '%s'...\n", releaseName)
+	if err := validator.Validate(origManifest, modifiedManifest); err != nil {
+		return report, errors.Wrapf(err, "release '%s' is not updated", releaseName)
+	}
+	for i, hook := range releaseToMap.Hooks {
+		if err := validator.Validate(hook.Manifest, modifiedHookManifests[i]); err != nil {
+			return report, errors.Wrapf(err, "release '%s' is not updated as hook '%s' is invalid", releaseName, hook.Name)
+		}
+	}
+
+	if m.options.DryRun {
+		log.Printf("Deprecated or removed APIs exist, for release: %s.\n", releaseName)
+	} else {
+		log.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
+		if m.options.Backups != nil {
+			if report.Backup, err = backupRelease(releaseToMap, newVersion, m.options.Backups); err != nil {
+				return report, errors.Wrapf(err, "failed to back up release '%s'", releaseName)
+			}
+		}
+		if err := updateRelease(releaseToMap, modifiedManifest, modifiedHookManifests, newVersion, m.releases); err != nil {
+			return report, errors.Wrapf(err, "failed to update release '%s'", releaseName)
+		}
+		report.NewRevision = newVersion
+		log.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
+	}
+
+	report.Status = common.StatusMapped
+	return report, nil
+}
+
+// Check checks the latest deployed version of the release, or the version of the options, for deprecated
+// or removed APIs, without updating the release or taking a backup. The release status in the report is
+// clean, deprecated or removed.
+func (m *Mapper) Check(releaseName string) (*common.ReleaseReport, error) {
+	report := m.newReleaseReport(releaseName)
+	report.DryRun = false
+
+	releaseToCheck, _, err := m.getRelease(releaseName, false)
+	if err != nil {
+		return report, err
+	}
+
+	mapper, err := m.newManifestMapper()
+	if err != nil {
+		return report, err
+	}
+
+	// the mapped manifests are only used to find the deprecated or removed APIs
+	if _, _, _, err := m.mapRelease(releaseToCheck, mapper, report); err != nil {
+		return report, err
+	}
+
+	switch {
+	case report.HasRemovedAPIs():
+		report.Status = common.StatusRemoved
+		log.Printf("Release '%s' has removed APIs.\n", releaseName)
+	case len(report.Mappings) > 0:
+		report.Status = common.StatusDeprecated
+		log.Printf("Release '%s' has deprecated APIs.\n", releaseName)
+	default:
+		report.Status = common.StatusClean
+		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
+	}
+	return report, nil
+}
+
+// List returns the latest version of every release in the release store of the mapper, see LatestReleases
+func (m *Mapper) List() ([]*release.Release, error) {
+	return LatestReleases(m.releases)
+}
+
+// LatestReleases returns the latest version of every release in the release store, sorted by namespace and name
+func LatestReleases(store ReleaseStore) ([]*release.Release, error) {
+	releases, err := store.ListReleases()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+
+	// the storage returns every version of a release, keep the latest one only
+	latest := make(map[string]*release.Release)
+	for _, rel := range releases {
+		key := rel.Namespace + "/" + rel.Name
+		if current, ok := latest[key]; !ok || rel.Version > current.Version {
+			latest[key] = rel
+		}
+	}
+
+	var latestReleases []*release.Release
+	for _, rel := range latest {
+		latestReleases = append(latestReleases, rel)
+	}
+	sort.Slice(latestReleases, func(i, j int) bool {
+		if latestReleases[i].Namespace != latestReleases[j].Namespace {
+			return latestReleases[i].Namespace < latestReleases[j].Namespace
+		}
+		return latestReleases[i].Name < latestReleases[j].Name
+	})
+	return latestReleases, nil
+}
+
+// getRelease returns the version of the release to map or check, and the latest version of the release.
+// It is the version of the options, or else the latest deployed version. While the latest version is
+// pending, the release is only updated if the version is set in the options: a new version after the
+// pending one lets Helm upgrade the release again, even if the pending operation is still running.
+func (m *Mapper) getRelease(releaseName string, update bool) (*release.Release, *release.Release, error) {
+	log.Printf("Get release '%s' latest version.\n", releaseName)
+	latestRelease, err := m.releases.Last(releaseName)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get release '%s' latest version", releaseName)
+	}
+	latestStatus := latestRelease.Info.Status
+
+	if m.options.Revision > 0 {
+		log.Printf("Get release '%s' version %d.\n", releaseName, m.options.Revision)
+		rel, err := m.releases.Get(releaseName, m.options.Revision)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to get release '%s' version %d", releaseName, m.options.Revision)
+		}
+		if update && rel.Info.Status != release.StatusDeployed {
+			deployed, err := m.releases.Deployed(releaseName)
+			if err == nil && deployed.Version != rel.Version {
+				return nil, nil, errors.Errorf("release '%s' version %d is '%s' while version %d is deployed, only the deployed version "+
+					"can be mapped when the release has one", releaseName, rel.Version, rel.Info.Status, deployed.Version)
+			}
+		}
+		if latestStatus.IsPending() && latestRelease.Version != rel.Version {
+			log.Printf("WARNING: Release '%s' latest version %d is '%s', make sure that no Helm operation is running on the release.\n",
+				releaseName, latestRelease.Version, latestStatus)
+		}
+		return rel, latestRelease, nil
+	}
+
+	if latestStatus == release.StatusDeployed {
+		return latestRelease, latestRelease, nil
+	}
+	deployed, err := m.releases.Deployed(releaseName)
+	switch {
+	case errors.Is(err, driver.ErrNoDeployedReleases) && latestStatus.IsPending():
+		return nil, nil, errors.Errorf("release '%s' has no deployed version and its latest version %d is stuck in '%s': a Helm operation "+
+			"on the release is still running or was interrupted. Wait for the operation to finish, or if it was interrupted, "+
+			"set the version to map explicitly", releaseName, latestRelease.Version, latestStatus)
+	case errors.Is(err, driver.ErrNoDeployedReleases):
+		return nil, nil, errors.Errorf("release '%s' has no deployed version and its latest version %d is '%s', "+
+			"set the version to map explicitly", releaseName, latestRelease.Version, latestStatus)
+	case err != nil:
+		return nil, nil, errors.Wrapf(err, "failed to get release '%s' deployed version", releaseName)
+	case update && latestStatus.IsPending():
+		return nil, nil, errors.Errorf("release '%s' latest version %d is stuck in '%s', so Helm does not upgrade or roll back the release "+
+			"until the operation finishes: the operation is still running or was interrupted. Mapping the deployed version %d adds "+
+			"a new version after version %d, which lets Helm upgrade the release again. If no Helm operation is running, set the "+
+			"version to map explicitly to %d", releaseName, latestRelease.Version, latestStatus, deployed.Version,
+			latestRelease.Version, deployed.Version)
+	}
+	log.Printf("Release '%s' latest version %d is '%s', use the latest deployed version %d.\n",
+		releaseName, latestRelease.Version, latestStatus, deployed.Version)
+	return deployed, latestRelease, nil
+}
+
+// newReleaseReport returns the report of the release, with the status set to failed until the release is checked
+func (m *Mapper) newReleaseReport(releaseName string) *common.ReleaseReport {
+	return &common.ReleaseReport{
+		Release:  releaseName,
+		Status:   common.StatusFailed,
+		DryRun:   m.options.DryRun,
+		Mappings: []common.MappedAPI{},
+	}
+}
+
+// newManifestMapper returns the manifest mapper of the current mapping data and Kubernetes version
+func (m *Mapper) newManifestMapper() (*common.ManifestMapper, error) {
+	mapMetadata, err := m.mappings.Mappings()
+	if err != nil {
+		return nil, err
+	}
+	kubeVersionStr, err := m.versions.KubeVersion()
+	if err != nil {
+		return nil, err
+	}
+	if err := common.CheckMapCoverage(mapMetadata, kubeVersionStr, m.options.Strict); err != nil {
+		return nil, err
+	}
+	if (m.options.MapToServed || m.options.ReportUnserved) && m.options.ServedAPIs == nil {
+		return nil, errors.New("the APIs the cluster serves are not set")
+	}
+	mapper := common.NewManifestMapperFor(mapMetadata, kubeVersionStr, m.options.MapOn, m.options.Structured)
+	if m.options.MapToServed {
+		mapper.SetServedAPIs(m.options.ServedAPIs)
+	}
+	return mapper, nil
+}
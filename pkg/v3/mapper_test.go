@@ -0,0 +1,223 @@
+package v3
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+var _ = ginkgo.Describe("mapping releases with a mapper", func() {
+	var releases *storage.Storage
+
+	ginkgo.BeforeEach(func() {
+		releases = storage.Init(driver.NewMemory())
+		gomega.Expect(releases.Create(newTestRelease(1, release.StatusSuperseded))).To(gomega.Succeed())
+		gomega.Expect(releases.Create(newTestRelease(2, release.StatusDeployed))).To(gomega.Succeed())
+	})
+
+	newTestMapper := func(options MapperOptions) *Mapper {
+		return NewMapper(releases, common.StaticVersion("v1.16.0"), common.MapFiles{}, options)
+	}
+
+	ginkgo.It("adds a release version with the mapped APIs", func() {
+		report, err := newTestMapper(MapperOptions{}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusMapped))
+		gomega.Expect(report.Namespace).To(gomega.Equal("test-ns"))
+		gomega.Expect(report.Revision).To(gomega.Equal(2))
+		gomega.Expect(report.NewRevision).To(gomega.Equal(3))
+		gomega.Expect(report.KubeVersion).To(gomega.Equal("v1.16.0"))
+
+		latest, err := releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest.Version).To(gomega.Equal(3))
+		gomega.Expect(latest.Manifest).To(gomega.ContainSubstring("apiVersion: apps/v1\n"))
+	})
+
+	ginkgo.It("takes a backup before updating the release", func() {
+		backups := &dirBackupStore{dir: ginkgo.GinkgoT().TempDir()}
+		_, err := newTestMapper(MapperOptions{Backups: backups}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		backup, err := backups.Latest("test", "test-ns")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(backup.Version).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("does not update the release in dry-run mode or when checking", func() {
+		report, err := newTestMapper(MapperOptions{DryRun: true}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusMapped))
+
+		report, err = newTestMapper(MapperOptions{}).Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusRemoved))
+
+		latest, err := releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest.Version).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("reports a release without deprecated APIs as clean", func() {
+		report, err := NewMapper(releases, common.StaticVersion("v1.8.0"), common.MapFiles{}, MapperOptions{}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusClean))
+	})
+
+	ginkgo.It("lists the latest version of each release", func() {
+		list, err := newTestMapper(MapperOptions{}).List()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(list).To(gomega.HaveLen(1))
+		gomega.Expect(list[0].Version).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("only maps to served APIs and reports unserved resources when asked to", func() {
+		served := common.NewServedAPIs([]*metav1.APIResourceList{{
+			GroupVersion: "apps/v1beta2",
+			APIResources: []metav1.APIResource{{Kind: "Deployment"}},
+		}})
+
+		report, err := newTestMapper(MapperOptions{ServedAPIs: served, MapToServed: true}).Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Mappings).To(gomega.HaveLen(1))
+		gomega.Expect(report.Mappings[0].Action).To(gomega.Equal(common.ActionKept))
+		gomega.Expect(report.Unsupported).To(gomega.BeEmpty())
+
+		report, err = newTestMapper(MapperOptions{ServedAPIs: served, ReportUnserved: true}).Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Mappings[0].Action).To(gomega.Equal(common.ActionReplaced))
+		gomega.Expect(report.Unsupported).To(gomega.HaveLen(1))
+
+		_, err = newTestMapper(MapperOptions{ReportUnserved: true}).Check("test")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("the APIs the cluster serves are not set")))
+	})
+
+	ginkgo.It("returns the report with an error when the release is not found", func() {
+		report, err := newTestMapper(MapperOptions{}).Map("missing")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(report.Release).To(gomega.Equal("missing"))
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusFailed))
+	})
+})
+
+var _ = ginkgo.Describe("selecting the release version to map", func() {
+	var releases *storage.Storage
+
+	ginkgo.BeforeEach(func() {
+		releases = storage.Init(driver.NewMemory())
+		gomega.Expect(releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+	})
+
+	newTestMapper := func(options MapperOptions) *Mapper {
+		return NewMapper(releases, common.StaticVersion("v1.16.0"), common.MapFiles{}, options)
+	}
+
+	ginkgo.It("maps the deployed version after a failed version", func() {
+		gomega.Expect(releases.Create(newTestRelease(2, release.StatusFailed))).To(gomega.Succeed())
+
+		report, err := newTestMapper(MapperOptions{}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Revision).To(gomega.Equal(1))
+		gomega.Expect(report.NewRevision).To(gomega.Equal(3))
+
+		for version, status := range map[int]release.Status{1: release.StatusSuperseded, 2: release.StatusFailed, 3: release.StatusDeployed} {
+			rel, err := releases.Get("test", version)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(rel.Info.Status).To(gomega.Equal(status))
+		}
+	})
+
+	ginkgo.It("maps the version of the options", func() {
+		gomega.Expect(releases.Update(newTestRelease(1, release.StatusFailed))).To(gomega.Succeed())
+		gomega.Expect(releases.Create(newTestRelease(2, release.StatusFailed))).To(gomega.Succeed())
+
+		_, err := newTestMapper(MapperOptions{}).Map("test")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("has no deployed version and its latest version 2 is 'failed'")))
+
+		report, err := newTestMapper(MapperOptions{Revision: 1}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Revision).To(gomega.Equal(1))
+		gomega.Expect(report.NewRevision).To(gomega.Equal(3))
+	})
+
+	ginkgo.It("does not map a version other than the deployed one", func() {
+		gomega.Expect(releases.Create(newTestRelease(2, release.StatusFailed))).To(gomega.Succeed())
+
+		_, err := newTestMapper(MapperOptions{Revision: 2}).Map("test")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("while version 1 is deployed")))
+	})
+
+	ginkgo.It("explains a release stuck in a pending version", func() {
+		gomega.Expect(releases.Create(newTestRelease(2, release.StatusPendingUpgrade))).To(gomega.Succeed())
+
+		_, err := newTestMapper(MapperOptions{}).Map("test")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("latest version 2 is stuck in 'pending-upgrade'")))
+
+		report, err := newTestMapper(MapperOptions{}).Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Revision).To(gomega.Equal(1))
+
+		report, err = newTestMapper(MapperOptions{Revision: 1}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.NewRevision).To(gomega.Equal(3))
+	})
+
+	ginkgo.It("explains a release stuck in a pending install", func() {
+		gomega.Expect(releases.Update(newTestRelease(1, release.StatusPendingInstall))).To(gomega.Succeed())
+
+		_, err := newTestMapper(MapperOptions{}).Check("test")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("has no deployed version and its latest version 1 is stuck in 'pending-install'")))
+	})
+})
+
+var _ = ginkgo.Describe("mapping the hooks of a release", func() {
+	var releases *storage.Storage
+
+	ginkgo.BeforeEach(func() {
+		rel := newTestRelease(1, release.StatusDeployed)
+		rel.Hooks = []*release.Hook{
+			{Name: "deployment-hook", Manifest: "---\napiVersion: apps/v1beta2\nkind: Deployment\nmetadata:\n  name: hook\n"},
+			{Name: "psp-hook", Manifest: "---\napiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata:\n  name: hook\n"},
+			{Name: "job-hook", Manifest: "---\napiVersion: batch/v1\nkind: Job\nmetadata:\n  name: hook\n"},
+		}
+		releases = storage.Init(driver.NewMemory())
+		gomega.Expect(releases.Create(rel)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("maps the hook manifests and drops the hooks left empty", func() {
+		report, err := NewMapper(releases, common.StaticVersion("v1.25.0"), common.MapFiles{}, MapperOptions{}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusMapped))
+
+		hooks := map[string]string{}
+		for _, mappedAPI := range report.Mappings {
+			hooks[mappedAPI.Hook] = mappedAPI.DeprecatedAPI
+		}
+		gomega.Expect(hooks).To(gomega.Equal(map[string]string{
+			"":                "apiVersion: apps/v1beta2\nkind: Deployment\n",
+			"deployment-hook": "apiVersion: apps/v1beta2\nkind: Deployment\n",
+			"psp-hook":        "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\n",
+		}))
+
+		latest, err := releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest.Hooks).To(gomega.HaveLen(2))
+		gomega.Expect(latest.Hooks[0].Name).To(gomega.Equal("deployment-hook"))
+		gomega.Expect(latest.Hooks[0].Manifest).To(gomega.Equal("---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: hook\n"))
+		gomega.Expect(latest.Hooks[1].Name).To(gomega.Equal("job-hook"))
+	})
+
+	ginkgo.It("shows the changed hooks in the diff", func() {
+		report, err := NewMapper(releases, common.StaticVersion("v1.25.0"), common.MapFiles{}, MapperOptions{Diff: true, DryRun: true}).Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(report.Diff).To(gomega.ContainSubstring("--- a/hooks/deployment-hook/Deployment/hook\n+++ b/hooks/deployment-hook/Deployment/hook\n"))
+		gomega.Expect(report.Diff).To(gomega.ContainSubstring("--- a/hooks/psp-hook/PodSecurityPolicy/hook\n+++ /dev/null\n"))
+		gomega.Expect(report.Diff).ToNot(gomega.ContainSubstring("job-hook"))
+	})
+})
@@ -17,13 +17,21 @@ limitations under the License.
 package v3
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/util/openapi"
+
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
 
 	common "github.com/helm/helm-mapkubeapis/pkg/common"
 	"github.com/helm/helm-mapkubeapis/pkg/mapping"
@@ -31,71 +39,358 @@ import (
 
 // MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
 // If it finds any, it will create a new release version with the APIs mapped to the supported versions
+//
+// Deprecated: use MapRelease, which also returns the report of the release.
 func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions, additionalMappings ...*mapping.Mapping) error {
+	_, err := MapRelease(mapOptions, additionalMappings...)
+	return err
+}
+
+// MapRelease maps the deprecated or removed APIs of the release in the map options, connecting to the Helm
+// storage and the Kubernetes server of the options, and returns the report of the release.
+func MapRelease(mapOptions common.MapOptions, additionalMappings ...*mapping.Mapping) (*common.ReleaseReport, error) {
+	report := newReleaseReport(mapOptions)
+
 	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
 	if err != nil {
-		return errors.Wrap(err, "failed to get Helm action configuration")
+		return report, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	mapper, err := newReleaseMapper(mapOptions, cfg, additionalMappings...)
+	if err != nil {
+		return report, err
+	}
+	if report, err = mapper.Map(mapOptions.ReleaseName); report.Namespace == "" {
+		report.Namespace = mapOptions.ReleaseNamespace
+	}
+	return report, err
+}
+
+// newReleaseReport returns the report of the release in the map options, with the status set to failed
+// until the release is checked
+func newReleaseReport(mapOptions common.MapOptions) *common.ReleaseReport {
+	return &common.ReleaseReport{
+		Release:   mapOptions.ReleaseName,
+		Namespace: mapOptions.ReleaseNamespace,
+		Status:    common.StatusFailed,
+		DryRun:    mapOptions.DryRun,
+		Mappings:  []common.MappedAPI{},
+	}
+}
+
+// newReleaseMapper returns a mapper of the releases of the Helm action configuration with the map options.
+// The Kubernetes version is the version in the map options, or else the version of the Kubernetes server.
+func newReleaseMapper(mapOptions common.MapOptions, cfg *action.Configuration, additionalMappings ...*mapping.Mapping) (*Mapper, error) {
+	mapMetadata, err := common.LoadMappings(mapOptions, additionalMappings...)
+	if err != nil {
+		return nil, err
 	}
 
-	var releaseName = mapOptions.ReleaseName
-	log.Printf("Get release '%s' latest version.\n", releaseName)
-	releaseToMap, err := getLatestRelease(releaseName, cfg)
+	options := MapperOptions{
+		DryRun:     mapOptions.DryRun,
+		Diff:       mapOptions.Diff,
+		MapOn:      mapOptions.MapOn,
+		Structured: mapOptions.Structured,
+		Strict:     mapOptions.Strict,
+		Revision:   mapOptions.Revision,
+		NewValidator: func(kubeVersionStr string) (*common.ManifestValidator, error) {
+			return newManifestValidator(mapOptions, kubeVersionStr, cfg)
+		},
+	}
+
+	// the Kubernetes server is not contacted if a Kubernetes version is set, unless the served APIs are discovered
+	var client discovery.DiscoveryInterface
+	if mapOptions.KubeVersion == "" || mapOptions.Discovery {
+		clientSet, err := common.NewClientSet(cfg.RESTClientGetter)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get Kubernetes client")
+		}
+		client = clientSet.Discovery()
+	}
+	versions, served, err := common.NewVersionSource(mapOptions, client)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get release '%s' latest version", mapOptions.ReleaseName)
+		return nil, err
+	}
+	options.ServedAPIs = served
+	options.MapToServed = served != nil
+
+	if mapOptions.Discovery {
+		options.ReportUnserved = true
+		if options.ServedAPIs == nil {
+			if options.ServedAPIs, err = common.DiscoverServedAPIs(client); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !mapOptions.DryRun {
+		if options.Backups, err = NewBackupStore(mapOptions, cfg); err != nil {
+			return nil, err
+		}
 	}
 
+	return NewMapper(cfg.Releases, versions, common.StaticMappings{Metadata: mapMetadata}, options), nil
+}
+
+// mapRelease maps the deprecated or removed APIs in the manifest and hooks of the release version, without
+// updating the release. The mapped APIs, and the diff and unsupported APIs when requested, are added to
+// the report. It returns the mapped manifest and hook manifests, and whether any of them was modified.
+func (m *Mapper) mapRelease(rel *release.Release, mapper *common.ManifestMapper, report *common.ReleaseReport) (string, []string, bool, error) {
+	releaseName := rel.Name
+	report.Namespace = rel.Namespace
+	report.Revision = rel.Version
+	report.KubeVersion = mapper.KubeVersion()
+
 	log.Printf("Check release '%s' for deprecated or removed APIs...\n", releaseName)
-	var origManifest = releaseToMap.Manifest
-	modifiedManifest, err := common.ReplaceManifestUnSupportedAPIs(origManifest, mapOptions.MapFile, mapOptions.KubeConfig, additionalMappings...)
+	var origManifest = rel.Manifest
+	modifiedManifest, mappedAPIs, err := mapper.Map(origManifest)
 	if err != nil {
-		return err
+		return "", nil, false, err
+	}
+	report.Mappings = append(report.Mappings, mappedAPIs...)
+	modified := modifiedManifest != origManifest
+	if m.options.Diff {
+		report.Diff = common.ManifestDiff("manifest", origManifest, modifiedManifest)
+	}
+
+	modifiedHookManifests := make([]string, len(rel.Hooks))
+	for i, hook := range rel.Hooks {
+		log.Printf("Check hook '%s' of release '%s' for deprecated or removed APIs...\n", hook.Name, releaseName)
+		if modifiedHookManifests[i], mappedAPIs, err = mapper.Map(hook.Manifest); err != nil {
+			return "", nil, false, errors.Wrapf(err, "failed to map hook '%s'", hook.Name)
+		}
+		for _, mappedAPI := range mappedAPIs {
+			mappedAPI.Hook = hook.Name
+			report.Mappings = append(report.Mappings, mappedAPI)
+		}
+		if m.options.Diff {
+			report.Diff += common.ManifestDiff("hooks/"+hook.Name, hook.Manifest, modifiedHookManifests[i])
+		}
+		if modifiedHookManifests[i] != hook.Manifest {
+			log.Printf("Hook '%s' of release '%s' has deprecated or removed APIs.\n", hook.Name, releaseName)
+			modified = true
+		}
+	}
+	if m.options.ReportUnserved {
+		report.Unsupported = findUnsupportedAPIs(m.options.ServedAPIs, modifiedManifest, rel.Hooks, modifiedHookManifests)
+		if len(report.Unsupported) > 0 {
+			report.Message = fmt.Sprintf("%d resources with APIs not served by the cluster", len(report.Unsupported))
+		}
 	}
 	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", releaseName)
-	if modifiedManifest == origManifest {
-		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
-		return nil
+	return modifiedManifest, modifiedHookManifests, modified, nil
+}
+
+// findUnsupportedAPIs returns the resources of the mapped manifests whose API the cluster does not serve.
+// These are APIs which are missing from the mapping file, or which the mapping could not map.
+func findUnsupportedAPIs(served *common.ServedAPIs, manifest string, hooks []*release.Hook, hookManifests []string) []common.UnsupportedAPI {
+	unsupported := served.Unsupported(manifest)
+	for i, hook := range hooks {
+		for _, unsupportedAPI := range served.Unsupported(hookManifests[i]) {
+			unsupportedAPI.Hook = hook.Name
+			unsupported = append(unsupported, unsupportedAPI)
+		}
+	}
+	for _, unsupportedAPI := range unsupported {
+		suggestion := "none"
+		if len(unsupportedAPI.SuggestedAPIVersions) > 0 {
+			suggestion = strings.Join(unsupportedAPI.SuggestedAPIVersions, ", ")
+		}
+		log.Printf("Resource '%s/%s' uses API \"%s\" which is not served by the Kubernetes server. Served API versions of kind %s: %s\n",
+			unsupportedAPI.Kind, unsupportedAPI.Name, unsupportedAPI.APIVersion, unsupportedAPI.Kind, suggestion)
 	}
+	return unsupported
+}
 
-	if mapOptions.DryRun {
-		log.Printf("Deprecated or removed APIs exist, for release: %s.\n", releaseName)
-	} else {
-		log.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
-		if err := updateRelease(releaseToMap, modifiedManifest, cfg); err != nil {
-			return errors.Wrapf(err, "failed to update release '%s'", releaseName)
+// newManifestValidator returns a validator for the mapped manifests, with the OpenAPI schema from the
+// schema directory or from the cluster if schema validation is enabled in the map options
+func newManifestValidator(mapOptions common.MapOptions, kubeVersionStr string, cfg *action.Configuration) (*common.ManifestValidator, error) {
+	var resources openapi.Resources
+	var err error
+	switch {
+	case mapOptions.SchemaDir != "":
+		log.Printf("Using the OpenAPI schema of Kubernetes version \"%s\" from %s.\n", kubeVersionStr, mapOptions.SchemaDir)
+		resources, err = common.LoadSchemaDir(mapOptions.SchemaDir, kubeVersionStr)
+	case mapOptions.Validate:
+		var clientSet kubernetes.Interface
+		if clientSet, err = common.NewClientSet(cfg.RESTClientGetter); err != nil {
+			return nil, errors.Wrap(err, "failed to get Kubernetes client")
 		}
-		log.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
+		resources, err = common.LoadDiscoverySchema(clientSet.Discovery())
+	}
+	if err != nil {
+		return nil, err
 	}
+	return common.NewManifestValidator(resources), nil
+}
 
-	return nil
+// backupRelease saves a backup of the release version before it is superseded by the new release version
+func backupRelease(rel *release.Release, newVersion int, store BackupStore) (string, error) {
+	backup, err := newBackup(rel, newVersion, time.Now())
+	if err != nil {
+		return "", err
+	}
+	location, err := store.Save(backup)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Release version '%s' backed up to %s.\n", getReleaseVersionName(rel), location)
+	return location, nil
 }
 
-func updateRelease(origRelease *release.Release, modifiedManifest string, cfg *action.Configuration) error {
+// updateRelease adds the new release version with the mapped manifests and supersedes the original
+// release version. The new version follows the latest release version, which is not always the
+// original release version, such as when the latest one failed. The new version is created first, so that the release is left unchanged if it
+// cannot be created. If the original version cannot be superseded afterwards, the new version is
+// deleted again, if the release store can delete release versions. The returned error describes the
+// state the release is left in.
+func updateRelease(origRelease *release.Release, modifiedManifest string, modifiedHookManifests []string, newVersion int, releases ReleaseStore) error {
+	// Using a deep copy of current release version to update the object with the modification
+	// and then store this new version, so that the original release version is left untouched
+	newRelease, err := copyRelease(origRelease)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy release version '%s', the release is left unchanged", getReleaseVersionName(origRelease))
+	}
+	newRelease.Manifest = modifiedManifest
+	newRelease.Hooks = mapHooks(newRelease.Hooks, modifiedHookManifests)
+	newRelease.Info.Description = common.UpgradeDescription
+	newRelease.Info.LastDeployed = helmtime.Now()
+	newRelease.Version = newVersion
+	newRelease.Info.Status = release.StatusDeployed
+	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(newRelease))
+	if err := releases.Create(newRelease); err != nil {
+		return errors.Wrapf(err, "failed to create new release version '%s', release version '%s' is left unchanged",
+			getReleaseVersionName(newRelease), getReleaseVersionName(origRelease))
+	}
+	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(newRelease))
+
 	// Update current release version to be superseded
 	log.Printf("Set status of release version '%s' to 'superseded'.\n", getReleaseVersionName(origRelease))
+	origStatus := origRelease.Info.Status
 	origRelease.Info.Status = release.StatusSuperseded
-	if err := cfg.Releases.Update(origRelease); err != nil {
-		return errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(origRelease))
+	if err := releases.Update(origRelease); err != nil {
+		origRelease.Info.Status = origStatus
+
+		deleter, ok := releases.(releaseDeleter)
+		if !ok {
+			return errors.Wrapf(err, "failed to update release version '%s': both release versions are left with status '%s' and '%s', "+
+				"delete release version '%s' to restore the release", getReleaseVersionName(origRelease),
+				origStatus, newRelease.Info.Status, getReleaseVersionName(newRelease))
+		}
+
+		// Roll back by deleting the new release version, so that the original one is the latest again
+		log.Printf("Failed to update release version '%s', delete release version '%s'.\n", getReleaseVersionName(origRelease), getReleaseVersionName(newRelease))
+		if _, deleteErr := deleter.Delete(newRelease.Name, newRelease.Version); deleteErr != nil {
+			return errors.Wrapf(err, "failed to update release version '%s' and failed to delete release version '%s' (%s): "+
+				"both release versions are left with status '%s' and '%s', delete release version '%s' to restore the release",
+				getReleaseVersionName(origRelease), getReleaseVersionName(newRelease), deleteErr,
+				origStatus, newRelease.Info.Status, getReleaseVersionName(newRelease))
+		}
+		return errors.Wrapf(err, "failed to update release version '%s', release version '%s' was deleted and the release is left unchanged",
+			getReleaseVersionName(origRelease), getReleaseVersionName(newRelease))
 	}
 	log.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(origRelease))
+	return nil
+}
 
-	// Using a shallow copy of current release version to update the object with the modification
-	// and then store this new version
-	var newRelease = origRelease
-	newRelease.Manifest = modifiedManifest
-	newRelease.Info.Description = common.UpgradeDescription
-	newRelease.Info.LastDeployed = cfg.Now()
-	newRelease.Version = origRelease.Version + 1
-	newRelease.Info.Status = release.StatusDeployed
-	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(origRelease))
-	if err := cfg.Releases.Create(newRelease); err != nil {
-		return errors.Wrapf(err, "failed to create new release version '%s'", getReleaseVersionName(origRelease))
+// copyRelease returns a deep copy of the release. The release is copied through its JSON
+// encoding, which is what the Helm storage drivers persist.
+func copyRelease(rel *release.Release) (*release.Release, error) {
+	b, err := json.Marshal(rel)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(origRelease))
-	return nil
+	var relCopy release.Release
+	if err := json.Unmarshal(b, &relCopy); err != nil {
+		return nil, err
+	}
+	return &relCopy, nil
 }
 
-func getLatestRelease(releaseName string, cfg *action.Configuration) (*release.Release, error) {
-	return cfg.Releases.Last(releaseName)
+// mapHooks returns the hooks with their mapped manifests. Hooks which have no resources
+// left after the mapping, because all their APIs were removed, are dropped.
+func mapHooks(hooks []*release.Hook, modifiedHookManifests []string) []*release.Hook {
+	var mappedHooks []*release.Hook
+	for i, hook := range hooks {
+		if strings.TrimSpace(modifiedHookManifests[i]) == "" && strings.TrimSpace(hook.Manifest) != "" {
+			log.Printf("Remove hook '%s' as it has no supported resources left.\n", hook.Name)
+			continue
+		}
+		hook.Manifest = modifiedHookManifests[i]
+		mappedHooks = append(mappedHooks, hook)
+	}
+	return mappedHooks
+}
+
+// ListReleases returns the latest version of every release in the namespace, or of every
+// release in the cluster if allNamespaces is set
+func ListReleases(namespace string, allNamespaces bool, kubeConfig common.KubeConfig) ([]*release.Release, error) {
+	var cfg *action.Configuration
+	var err error
+	if allNamespaces {
+		cfg, err = GetActionConfigAllNamespaces(kubeConfig)
+	} else {
+		cfg, err = GetActionConfig(namespace, kubeConfig)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	return LatestReleases(cfg.Releases)
+}
+
+// ForEachRelease maps or checks the latest version of every release in the namespace of the map options,
+// or of every release in the cluster if allNamespaces is set, with fn. The map options passed to fn are
+// set to the name and namespace of the release. A release which is being or was uninstalled is skipped
+// and gets a skipped report. A release which fails does not stop the others: the error is set in the
+// message of its report. An error is only returned if the releases cannot be listed.
+func ForEachRelease(mapOptions common.MapOptions, allNamespaces bool, fn func(common.MapOptions) (*common.ReleaseReport, error)) ([]*common.ReleaseReport, error) {
+	releases, err := ListReleases(mapOptions.ReleaseNamespace, allNamespaces, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Found %d releases to check for deprecated or removed Kubernetes APIs.\n", len(releases))
+
+	reports := []*common.ReleaseReport{}
+	for _, rel := range releases {
+		if isSkipped(rel) {
+			reports = append(reports, newSkippedReport(rel, mapOptions.DryRun))
+			continue
+		}
+
+		releaseOptions := mapOptions
+		releaseOptions.ReleaseName = rel.Name
+		releaseOptions.ReleaseNamespace = rel.Namespace
+		report, err := fn(releaseOptions)
+		if err != nil {
+			report.Message = err.Error()
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// isSkipped returns true if the release is not mapped or checked as it is being or was uninstalled
+func isSkipped(rel *release.Release) bool {
+	switch rel.Info.Status {
+	case release.StatusUninstalled, release.StatusUninstalling:
+		return true
+	}
+	return false
+}
+
+// newSkippedReport returns the report of a release which is not mapped or checked
+func newSkippedReport(rel *release.Release, dryRun bool) *common.ReleaseReport {
+	log.Printf("Skip release '%s' in namespace '%s' as it is %s.\n", rel.Name, rel.Namespace, rel.Info.Status)
+	return &common.ReleaseReport{
+		Release:   rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Status:    common.StatusSkipped,
+		Message:   fmt.Sprintf("release is %s", rel.Info.Status),
+		DryRun:    dryRun,
+		Mappings:  []common.MappedAPI{},
+	}
 }
 
 func getReleaseVersionName(rel *release.Release) string {
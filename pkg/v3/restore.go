@@ -0,0 +1,87 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// RestoreRelease restores a release to the state before it was mapped, using the latest backup of the
+// release. The release version added by the mapping is deleted and the original release version is
+// written back as it was stored, including its status. The backup is deleted once it is restored, so
+// that a release mapped several times can be restored step by step.
+func RestoreRelease(mapOptions common.MapOptions) error {
+	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	var releaseName = mapOptions.ReleaseName
+	log.Printf("Get release '%s' latest version.\n", releaseName)
+	latestRelease, err := cfg.Releases.Last(releaseName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get release '%s' latest version", releaseName)
+	}
+
+	store, err := NewBackupStore(mapOptions, cfg)
+	if err != nil {
+		return err
+	}
+	backup, err := store.Latest(releaseName, latestRelease.Namespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get backup of release '%s'", releaseName)
+	}
+	origRelease, err := backup.Decode()
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode backup of release '%s'", releaseName)
+	}
+	log.Printf("Found backup of release version '%s', taken at %s.\n", getReleaseVersionName(origRelease), backup.Created.Format("2006-01-02 15:04:05"))
+
+	if latestRelease.Version > backup.NewVersion {
+		return errors.Errorf("release '%s' has version %d which is newer than version %d added by the mapping, "+
+			"restoring the backup would lose it", releaseName, latestRelease.Version, backup.NewVersion)
+	}
+
+	if mapOptions.DryRun {
+		log.Printf("Release '%s' would be restored to version '%s' with status '%s'.\n", releaseName, getReleaseVersionName(origRelease), origRelease.Info.Status)
+		return nil
+	}
+
+	if latestRelease.Version == backup.NewVersion {
+		log.Printf("Delete release version '%s.v%d' added by the mapping.\n", releaseName, backup.NewVersion)
+		if _, err := cfg.Releases.Delete(releaseName, backup.NewVersion); err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+			return errors.Wrapf(err, "failed to delete release version '%s.v%d'", releaseName, backup.NewVersion)
+		}
+	}
+
+	log.Printf("Restore release version '%s' with status '%s'.\n", getReleaseVersionName(origRelease), origRelease.Info.Status)
+	if err := cfg.Releases.Update(origRelease); err != nil {
+		return errors.Wrapf(err, "failed to restore release version '%s'", getReleaseVersionName(origRelease))
+	}
+
+	if err := store.Delete(backup); err != nil {
+		log.Printf("Failed to delete the restored backup of release version '%s': %s\n", getReleaseVersionName(origRelease), err)
+	}
+	log.Printf("Release '%s' restored successfully.\n", releaseName)
+	return nil
+}
@@ -0,0 +1,411 @@
+package v3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+func TestV3(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Helm v3 release mapping suite")
+}
+
+func newTestRelease(version int, status release.Status) *release.Release {
+	return &release.Release{
+		Name:      "test",
+		Namespace: "test-ns",
+		Version:   version,
+		Manifest:  "---\napiVersion: apps/v1beta2\nkind: Deployment\nmetadata:\n  name: test\n",
+		Info:      &release.Info{Status: status},
+	}
+}
+
+var _ = ginkgo.Describe("release backups", func() {
+	ginkgo.It("stores and restores the release in a directory", func() {
+		store := &dirBackupStore{dir: ginkgo.GinkgoT().TempDir()}
+
+		for _, version := range []int{1, 2} {
+			backup, err := newBackup(newTestRelease(version, release.StatusDeployed), version+1, time.Now())
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			_, err = store.Save(backup)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+
+		backup, err := store.Latest("test", "test-ns")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(backup.Version).To(gomega.Equal(2))
+		gomega.Expect(backup.NewVersion).To(gomega.Equal(3))
+
+		rel, err := backup.Decode()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(rel).To(gomega.Equal(newTestRelease(2, release.StatusDeployed)))
+
+		gomega.Expect(store.Delete(backup)).To(gomega.Succeed())
+		backup, err = store.Latest("test", "test-ns")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(backup.Version).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("returns an error when there is no backup", func() {
+		store := &dirBackupStore{dir: ginkgo.GinkgoT().TempDir()}
+
+		_, err := store.Latest("test", "test-ns")
+		gomega.Expect(err).To(gomega.MatchError(ErrBackupNotFound))
+	})
+})
+
+// failingUpdateDriver is a memory storage driver which fails to update releases
+type failingUpdateDriver struct {
+	*driver.Memory
+}
+
+func (d *failingUpdateDriver) Update(_ string, _ *release.Release) error {
+	return errors.New("update refused")
+}
+
+var _ = ginkgo.Describe("updating a release", func() {
+	var mappedManifest = "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: test\n"
+
+	ginkgo.It("supersedes the original version with a new version", func() {
+		cfg := &action.Configuration{Releases: storage.Init(driver.NewMemory())}
+		gomega.Expect(cfg.Releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+		rel, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(updateRelease(rel, mappedManifest, nil, 2, cfg.Releases)).To(gomega.Succeed())
+
+		orig, err := cfg.Releases.Get("test", 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(orig.Info.Status).To(gomega.Equal(release.StatusSuperseded))
+		gomega.Expect(orig.Manifest).To(gomega.Equal(newTestRelease(1, release.StatusDeployed).Manifest))
+
+		latest, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest.Version).To(gomega.Equal(2))
+		gomega.Expect(latest.Info.Status).To(gomega.Equal(release.StatusDeployed))
+		gomega.Expect(latest.Manifest).To(gomega.Equal(mappedManifest))
+	})
+
+	ginkgo.It("does not modify the original release when building the new version", func() {
+		cfg := &action.Configuration{Releases: storage.Init(driver.NewMemory())}
+		rel := newTestRelease(1, release.StatusDeployed)
+		rel.Config = map[string]interface{}{"replicas": 1}
+		rel.Hooks = []*release.Hook{{Name: "test-hook", Manifest: rel.Manifest}}
+		gomega.Expect(cfg.Releases.Create(rel)).To(gomega.Succeed())
+
+		gomega.Expect(updateRelease(rel, mappedManifest, []string{mappedManifest}, 2, cfg.Releases)).To(gomega.Succeed())
+
+		latest, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest).ToNot(gomega.BeIdenticalTo(rel))
+		gomega.Expect(latest.Hooks[0].Manifest).To(gomega.Equal(mappedManifest))
+		latest.Config["replicas"] = 2
+
+		gomega.Expect(rel.Version).To(gomega.Equal(1))
+		gomega.Expect(rel.Manifest).To(gomega.Equal(newTestRelease(1, release.StatusDeployed).Manifest))
+		gomega.Expect(rel.Hooks[0].Manifest).To(gomega.Equal(newTestRelease(1, release.StatusDeployed).Manifest))
+		gomega.Expect(rel.Config["replicas"]).To(gomega.Equal(1))
+		gomega.Expect(rel.Info.Status).To(gomega.Equal(release.StatusSuperseded))
+	})
+
+	ginkgo.It("leaves the release unchanged if the new version cannot be created", func() {
+		cfg := &action.Configuration{Releases: storage.Init(driver.NewMemory())}
+		gomega.Expect(cfg.Releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+		gomega.Expect(cfg.Releases.Create(newTestRelease(2, release.StatusFailed))).To(gomega.Succeed())
+
+		err := updateRelease(newTestRelease(1, release.StatusDeployed), mappedManifest, nil, 2, cfg.Releases)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("release version 'test.v1' is left unchanged")))
+
+		orig, err := cfg.Releases.Get("test", 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(orig.Info.Status).To(gomega.Equal(release.StatusDeployed))
+	})
+
+	ginkgo.It("deletes the new version if the original version cannot be superseded", func() {
+		cfg := &action.Configuration{Releases: storage.Init(&failingUpdateDriver{driver.NewMemory()})}
+		gomega.Expect(cfg.Releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+		rel, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = updateRelease(rel, mappedManifest, nil, 2, cfg.Releases)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("release version 'test.v2' was deleted and the release is left unchanged")))
+
+		latest, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(latest.Version).To(gomega.Equal(1))
+		gomega.Expect(latest.Info.Status).To(gomega.Equal(release.StatusDeployed))
+	})
+})
+
+var _ = ginkgo.Describe("connecting to the Helm storage", func() {
+	ginkgo.It("uses the Helm environment settings", func() {
+		ginkgo.GinkgoT().Setenv("HELM_KUBEAPISERVER", "https://helm-env.example.com:6443")
+		ginkgo.GinkgoT().Setenv("HELM_DRIVER", "memory")
+
+		cfg, err := GetActionConfig("test-ns", common.KubeConfig{})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		config, err := cfg.RESTClientGetter.ToRESTConfig()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(config.Host).To(gomega.Equal("https://helm-env.example.com:6443"))
+	})
+})
+
+var _ = ginkgo.Describe("mapping releases of a storage driver", func() {
+	var kubeConfig common.KubeConfig
+
+	ginkgo.BeforeEach(func() {
+		releaseFile := filepath.Join(ginkgo.GinkgoT().TempDir(), "releases.yaml")
+		b, err := yaml.Marshal([]*release.Release{newTestRelease(1, release.StatusDeployed)})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(os.WriteFile(releaseFile, b, 0o600)).To(gomega.Succeed())
+		kubeConfig = common.KubeConfig{StorageDriver: "memory", StorageConnection: releaseFile}
+	})
+
+	ginkgo.It("loads the releases into the memory driver", func() {
+		cfg, err := GetActionConfig("test-ns", kubeConfig)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		rel, err := cfg.Releases.Last("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(rel.Version).To(gomega.Equal(1))
+
+		releases, err := ListReleases("", true, kubeConfig)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(releases).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("checks and maps a release", func() {
+		mapOptions := common.MapOptions{
+			BackupDir:        ginkgo.GinkgoT().TempDir(),
+			KubeConfig:       kubeConfig,
+			KubeVersion:      "v1.16.0",
+			ReleaseName:      "test",
+			ReleaseNamespace: "test-ns",
+		}
+
+		report, err := CheckRelease(mapOptions)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusRemoved))
+
+		report, err = MapRelease(mapOptions)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusMapped))
+		gomega.Expect(report.NewRevision).To(gomega.Equal(2))
+
+		backup, err := (&dirBackupStore{dir: mapOptions.BackupDir}).Latest("test", "test-ns")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(backup.Version).To(gomega.Equal(1))
+
+		// the releases are loaded again from the file, so the release is mapped again
+		gomega.Expect(MapReleaseWithUnSupportedAPIs(mapOptions)).To(gomega.Succeed())
+	})
+
+	// mapAndCheck checks and maps the test release in the release storage
+	mapAndCheck := func(releases ReleaseStore) {
+		mapper := NewMapper(releases, common.StaticVersion("v1.16.0"), common.MapFiles{}, MapperOptions{})
+
+		report, err := mapper.Check("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusRemoved))
+
+		report, err = mapper.Map("test")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(report.Status).To(gomega.Equal(common.StatusMapped))
+		gomega.Expect(report.NewRevision).To(gomega.Equal(2))
+	}
+
+	for helmDriver, newDriver := range map[string]func(*fake.Clientset) driver.Driver{
+		"secret": func(client *fake.Clientset) driver.Driver {
+			return driver.NewSecrets(client.CoreV1().Secrets("test-ns"))
+		},
+		"configmap": func(client *fake.Clientset) driver.Driver {
+			return driver.NewConfigMaps(client.CoreV1().ConfigMaps("test-ns"))
+		},
+	} {
+		ginkgo.It("checks and maps a release of the "+helmDriver+" driver", func() {
+			releases := storage.Init(newDriver(fake.NewSimpleClientset()))
+			gomega.Expect(releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+			mapAndCheck(releases)
+
+			rel, err := releases.Last("test")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(rel.Version).To(gomega.Equal(2))
+			gomega.Expect(rel.Manifest).To(gomega.ContainSubstring("apiVersion: apps/v1\n"))
+			rel, err = releases.Get("test", 1)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(rel.Info.Status).To(gomega.Equal(release.StatusSuperseded))
+		})
+	}
+
+	ginkgo.It("checks and maps a release of a store other than the Helm storage", func() {
+		releases := &fakeReleaseStore{releases: []*release.Release{newTestRelease(1, release.StatusDeployed)}}
+		mapAndCheck(releases)
+
+		gomega.Expect(releases.calls).To(gomega.Equal([]string{"create test.v2 deployed", "update test.v1 superseded"}))
+	})
+
+	ginkgo.It("checks and maps a release of the sql driver", func() {
+		// the sql driver needs a PostgreSQL database, such as postgres://postgres@localhost/helm?sslmode=disable
+		connection := os.Getenv("MAPKUBEAPIS_TEST_SQL_CONNECTION_STRING")
+		if connection == "" {
+			ginkgo.Skip("MAPKUBEAPIS_TEST_SQL_CONNECTION_STRING is not set")
+		}
+		cfg, err := GetActionConfig("test-ns", common.KubeConfig{StorageDriver: "sql", StorageConnection: connection})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(cfg.Releases.Name()).To(gomega.Equal(driver.SQLDriverName))
+		gomega.Expect(cfg.Releases.Create(newTestRelease(1, release.StatusDeployed))).To(gomega.Succeed())
+		ginkgo.DeferCleanup(func() {
+			for _, version := range []int{1, 2} {
+				_, _ = cfg.Releases.Delete("test", version)
+			}
+		})
+		mapAndCheck(cfg.Releases)
+	})
+
+	ginkgo.It("does not connect to the cluster until the secret driver is used", func() {
+		kubeConfig := common.KubeConfig{File: filepath.Join(ginkgo.GinkgoT().TempDir(), "missing"), StorageDriver: "secret"}
+		cfg, err := GetActionConfig("test-ns", kubeConfig)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(cfg.Releases.Name()).To(gomega.Equal(driver.SecretsDriverName))
+
+		_, err = cfg.Releases.Last("test")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("rejects a connection for the secret driver", func() {
+		_, err := GetActionConfig("test-ns", common.KubeConfig{StorageDriver: "secret", StorageConnection: "test"})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("not supported by the secret driver")))
+	})
+
+	ginkgo.It("connects the sql driver with the connection string", func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		connection := "postgres://helm@" + server.Listener.Addr().String() + "/helm?sslmode=disable&connect_timeout=1"
+
+		_, err := GetActionConfig("test-ns", common.KubeConfig{StorageDriver: "sql", StorageConnection: connection})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unable to instantiate SQL driver")))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(server.Listener.Addr().String())))
+	})
+})
+
+// fakeReleaseStore is a release store which records how the releases are changed
+type fakeReleaseStore struct {
+	releases []*release.Release
+	calls    []string
+}
+
+func (s *fakeReleaseStore) Last(name string) (*release.Release, error) {
+	return s.Get(name, len(s.releases))
+}
+
+func (s *fakeReleaseStore) Deployed(name string) (*release.Release, error) {
+	for i := len(s.releases) - 1; i >= 0; i-- {
+		if s.releases[i].Name == name && s.releases[i].Info.Status == release.StatusDeployed {
+			return s.releases[i], nil
+		}
+	}
+	return nil, driver.ErrNoDeployedReleases
+}
+
+func (s *fakeReleaseStore) Get(name string, version int) (*release.Release, error) {
+	for _, rel := range s.releases {
+		if rel.Name == name && rel.Version == version {
+			return rel, nil
+		}
+	}
+	return nil, driver.ErrReleaseNotFound
+}
+
+func (s *fakeReleaseStore) ListReleases() ([]*release.Release, error) {
+	return s.releases, nil
+}
+
+func (s *fakeReleaseStore) Create(rel *release.Release) error {
+	s.calls = append(s.calls, fmt.Sprintf("create %s.v%d %s", rel.Name, rel.Version, rel.Info.Status))
+	s.releases = append(s.releases, rel)
+	return nil
+}
+
+func (s *fakeReleaseStore) Update(rel *release.Release) error {
+	s.calls = append(s.calls, fmt.Sprintf("update %s.v%d %s", rel.Name, rel.Version, rel.Info.Status))
+	s.releases[rel.Version-1] = rel
+	return nil
+}
+
+var _ = ginkgo.Describe("mapping every release", func() {
+	var mapOptions common.MapOptions
+
+	ginkgo.BeforeEach(func() {
+		clean := newTestRelease(1, release.StatusDeployed)
+		clean.Name = "clean"
+		clean.Manifest = "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: clean\n"
+		uninstalled := newTestRelease(1, release.StatusUninstalled)
+		uninstalled.Name = "uninstalled"
+		stuck := newTestRelease(1, release.StatusPendingInstall)
+		stuck.Name = "stuck"
+		stuck.Namespace = "other-ns"
+
+		releaseFile := filepath.Join(ginkgo.GinkgoT().TempDir(), "releases.yaml")
+		b, err := yaml.Marshal([]*release.Release{
+			newTestRelease(1, release.StatusSuperseded), newTestRelease(2, release.StatusDeployed), clean, uninstalled, stuck,
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(os.WriteFile(releaseFile, b, 0o600)).To(gomega.Succeed())
+		mapOptions = common.MapOptions{
+			DryRun:      true,
+			KubeConfig:  common.KubeConfig{StorageDriver: "memory", StorageConnection: releaseFile},
+			KubeVersion: "v1.16.0",
+		}
+	})
+
+	ginkgo.It("maps the latest version of every release and continues after a release fails", func() {
+		var mapped []string
+		reports, err := ForEachRelease(mapOptions, true, func(options common.MapOptions) (*common.ReleaseReport, error) {
+			mapped = append(mapped, options.ReleaseNamespace+"/"+options.ReleaseName)
+			return MapRelease(options)
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(mapped).To(gomega.Equal([]string{"other-ns/stuck", "test-ns/clean", "test-ns/test"}))
+
+		statuses := map[string]common.ReleaseStatus{}
+		for _, report := range reports {
+			statuses[report.Namespace+"/"+report.Release] = report.Status
+		}
+		gomega.Expect(statuses).To(gomega.Equal(map[string]common.ReleaseStatus{
+			"other-ns/stuck":      common.StatusFailed,
+			"test-ns/clean":       common.StatusClean,
+			"test-ns/test":        common.StatusMapped,
+			"test-ns/uninstalled": common.StatusSkipped,
+		}))
+		gomega.Expect(reports[0].Message).To(gomega.ContainSubstring("has no deployed version"))
+		gomega.Expect(reports[2].Revision).To(gomega.Equal(2))
+		gomega.Expect(reports[3].Message).To(gomega.Equal("release is uninstalled"))
+		gomega.Expect(reports[3].DryRun).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("only maps the releases of the namespace", func() {
+		mapOptions.ReleaseNamespace = "other-ns"
+		reports, err := ForEachRelease(mapOptions, false, func(options common.MapOptions) (*common.ReleaseReport, error) {
+			return CheckRelease(options)
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(reports).To(gomega.HaveLen(1))
+		gomega.Expect(reports[0].Release).To(gomega.Equal("stuck"))
+	})
+})